@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+/*
+An option of a resource, as its spec describes it. Default is the json of the
+default value, and empty when there's none
+*/
+type Option struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+/*
+A resource of a plugin, with what it can be used as and the options it takes,
+sorted by name
+*/
+type Resource struct {
+	Plugin  string    `json:"plugin"`
+	Name    string    `json:"name"`
+	Kinds   []string  `json:"kinds"`
+	Options []*Option `json:"options"`
+}
+
+func kinds(resource *sdk.Resource) []string {
+	found := make([]string, 0, 3)
+	if resource.Kinds&sdk.PRODUCER != 0 {
+		found = append(found, "producer")
+	}
+
+	if resource.Kinds&sdk.CONSUMER != 0 {
+		found = append(found, "consumer")
+	}
+
+	if resource.Kinds&sdk.TRANSFORMER != 0 {
+		found = append(found, "transformer")
+	}
+
+	return found
+}
+
+func defaultOf(spec *sdk.Spec) string {
+	value := spec.Default
+	if value == cty.NilVal || value.IsNull() || !value.IsWhollyKnown() {
+		return ""
+	}
+
+	encoded, err := ctyjson.Marshal(value, value.Type())
+	if err != nil {
+		return value.GoString()
+	}
+
+	return string(encoded)
+}
+
+/*
+Every resource of plugins, sorted by name. Where plugins have resources of the
+same name, the one that a pipeline would get is the last
+*/
+func Describe(plugins []*sdk.Plugin) []*Resource {
+	found := make(map[string]*Resource)
+	for _, plugin := range plugins {
+		for _, resource := range plugin.Resources {
+			described := &Resource{Plugin: plugin.Name, Name: resource.Name, Kinds: kinds(resource), Options: make([]*Option, 0, len(resource.Spec))}
+			for name, spec := range resource.Spec {
+				described.Options = append(described.Options, &Option{
+					Name:        name,
+					Type:        cty.Type(spec.Type).FriendlyName(),
+					Required:    spec.Required,
+					Default:     defaultOf(spec),
+					Description: spec.Description,
+				})
+			}
+
+			sort.Slice(described.Options, func(i, j int) bool { return described.Options[i].Name < described.Options[j].Name })
+			found[resource.Name] = described
+		}
+	}
+
+	resources := make([]*Resource, 0, len(found))
+	for _, resource := range found {
+		resources = append(resources, resource)
+	}
+
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+	return resources
+}
+
+/*
+Find the resource called name among resources
+*/
+func Lookup(resources []*Resource, name string) (*Resource, error) {
+	for _, resource := range resources {
+		if resource.Name == name {
+			return resource, nil
+		}
+	}
+
+	return nil, fmt.Errorf("can't find resource %s", name)
+}
+
+/*
+Write a line for each of resources, with the plugin it's from and its kinds
+*/
+func WriteList(w io.Writer, resources []*Resource) error {
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "%-24s %-16s %s\n", "RESOURCE", "PLUGIN", "KINDS")
+	for _, resource := range resources {
+		fmt.Fprintf(out, "%-24s %-16s %s\n", resource.Name, resource.Plugin, strings.Join(resource.Kinds, ", "))
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+/*
+Write the options of resource, one after another
+*/
+func WriteDescription(w io.Writer, resource *Resource) error {
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "%s from %s, a %s\n", resource.Name, resource.Plugin, strings.Join(resource.Kinds, " and "))
+	if len(resource.Options) == 0 {
+		fmt.Fprintln(out, "\ntakes no options")
+	}
+
+	for _, option := range resource.Options {
+		required := "optional"
+		if option.Required {
+			required = "required"
+		}
+
+		fmt.Fprintf(out, "\n%s (%s, %s)\n", option.Name, option.Type, required)
+		if option.Default != "" {
+			fmt.Fprintf(out, "  default: %s\n", option.Default)
+		}
+
+		if option.Description != "" {
+			fmt.Fprintf(out, "  %s\n", option.Description)
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
+
+/*
+Write documentation of resources as markdown, with a section for each
+*/
+func WriteMarkdown(w io.Writer, resources []*Resource) error {
+	out := new(strings.Builder)
+	fmt.Fprintln(out, "# Resources")
+	for _, resource := range resources {
+		fmt.Fprintf(out, "\n## %s\n\n", resource.Name)
+		fmt.Fprintf(out, "From the `%s` plugin, usable as a %s.\n", resource.Plugin, strings.Join(resource.Kinds, " or "))
+		if len(resource.Options) == 0 {
+			fmt.Fprintln(out, "\nTakes no options.")
+			continue
+		}
+
+		fmt.Fprintln(out, "\n| Option | Type | Required | Default | Description |")
+		fmt.Fprintln(out, "| --- | --- | --- | --- | --- |")
+		for _, option := range resource.Options {
+			fallback := ""
+			if option.Default != "" {
+				fallback = "`" + option.Default + "`"
+			}
+
+			fmt.Fprintf(out, "| `%s` | %s | %t | %s | %s |\n", option.Name, option.Type, option.Required, markdownCell(fallback), markdownCell(option.Description))
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,106 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var plugins = []*sdk.Plugin{
+	{
+		Name: "queue",
+		Resources: []*sdk.Resource{
+			{
+				Name:  "channel",
+				Kinds: sdk.PRODUCER | sdk.CONSUMER,
+				Spec: sdk.SpecMap{
+					"size": &sdk.Spec{Name: "size", Description: "how many | fit", Type: cty.Number, Default: cty.NumberIntVal(10)},
+					"name": &sdk.Spec{Name: "name", Description: "name of the queue", Type: cty.String, Required: true},
+				},
+			},
+			{Name: "trash", Kinds: sdk.CONSUMER},
+		},
+	},
+	{
+		Name: "other",
+		Resources: []*sdk.Resource{
+			{
+				Name:  "trash",
+				Kinds: sdk.TRANSFORMER,
+				Spec: sdk.SpecMap{
+					"tags": &sdk.Spec{Name: "tags", Type: cty.List(cty.String), Default: cty.ListVal([]cty.Value{cty.StringVal("a")})},
+				},
+			},
+		},
+	},
+}
+
+func TestDescribe(test *testing.T) {
+	resources := Describe(plugins)
+	if !assert.Len(test, resources, 2) {
+		return
+	}
+
+	channel, trash := resources[0], resources[1]
+	assert.Equal(test, "queue", channel.Plugin)
+	assert.Equal(test, []string{"producer", "consumer"}, channel.Kinds)
+	assert.Equal(test, []*Option{
+		{Name: "name", Type: "string", Required: true, Description: "name of the queue"},
+		{Name: "size", Type: "number", Default: "10", Description: "how many | fit"},
+	}, channel.Options)
+
+	assert.Equal(test, "other", trash.Plugin, "the last plugin with a resource is the one used")
+	assert.Equal(test, []string{"transformer"}, trash.Kinds)
+	assert.Equal(test, []*Option{{Name: "tags", Type: "list of string", Default: `["a"]`}}, trash.Options)
+
+	_, err := Lookup(resources, "nope")
+	assert.NotNil(test, err)
+
+	found, err := Lookup(resources, "trash")
+	assert.Nil(test, err)
+	assert.Same(test, trash, found)
+}
+
+func TestWriteDescription(test *testing.T) {
+	out := new(strings.Builder)
+	if err := WriteDescription(out, Describe(plugins)[0]); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, `channel from queue, a producer and consumer
+
+name (string, required)
+  name of the queue
+
+size (number, optional)
+  default: 10
+  how many | fit
+`, out.String())
+}
+
+func TestWriteMarkdown(test *testing.T) {
+	out := new(strings.Builder)
+	if err := WriteMarkdown(out, Describe(plugins)); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Contains(test, out.String(), "## channel\n\nFrom the `queue` plugin, usable as a producer or consumer.\n")
+	assert.Contains(test, out.String(), "| `name` | string | true |  | name of the queue |\n")
+	assert.Contains(test, out.String(), "| `size` | number | false | `10` | how many \\| fit |\n")
+	assert.Contains(test, out.String(), "| `tags` | list of string | false | `[\"a\"]` |  |\n")
+}
+
+func TestWriteList(test *testing.T) {
+	out := new(strings.Builder)
+	if err := WriteList(out, Describe(plugins)); err != nil {
+		test.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(test, lines, 3)
+	assert.Equal(test, []string{"channel", "queue", "producer,", "consumer"}, strings.Fields(lines[1]))
+	assert.Equal(test, []string{"trash", "other", "transformer"}, strings.Fields(lines[2]))
+}
@@ -0,0 +1,94 @@
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+/*
+Implemented by the config of a producer that can pick up where it left off.
+
+Resume is called once the config is parsed, with the position that a previous run
+had gotten to ( nil if there's none ). Before sending each message, the producer
+must call mark with the position just after that message, so that resuming from
+it won't produce that message again. Positions are opaque to psyduck
+*/
+type Resumable interface {
+	Resume(position []byte, mark func(position []byte)) error
+}
+
+/*
+Implemented by the config of a transformer that keeps state between runs. Restore
+is called once the config is parsed, with the store of the pipeline it's part of
+and the stage that its state should be kept under in it
+*/
+type Stateful interface {
+	Restore(store *Store, stage string) error
+}
+
+/*
+Positions of the producers of one pipeline, kept as one file per producer in a
+directory
+*/
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir}
+}
+
+func (store *Store) path(stage string) string {
+	return filepath.Join(store.dir, url.PathEscape(stage))
+}
+
+/*
+The position saved for stage, or nil if nothing's been saved
+*/
+func (store *Store) Load(stage string) ([]byte, error) {
+	position, err := os.ReadFile(store.path(stage))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return position, err
+}
+
+/*
+Save the position of stage. The previous position is replaced all at once, so
+that it's kept whole if saving fails partway
+*/
+func (store *Store) Save(stage string, position []byte) error {
+	if err := os.MkdirAll(store.dir, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(store.dir, ".saving-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(position); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), store.path(stage)); err != nil {
+		return fmt.Errorf("failed replacing position of %s: %s", stage, err)
+	}
+
+	return nil
+}
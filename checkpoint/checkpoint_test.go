@@ -0,0 +1,39 @@
+package checkpoint
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(test *testing.T) {
+	store := NewStore(test.TempDir() + "/state/test")
+
+	position, err := store.Load("produce.file.lines")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Nil(test, position)
+
+	for _, want := range []string{"12", "345"} {
+		if err := store.Save("produce.file.lines", []byte(want)); err != nil {
+			test.Fatal(err)
+		}
+
+		position, err = store.Load("produce.file.lines")
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		assert.Equal(test, want, string(position))
+	}
+
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Len(test, entries, 1, "nothing but the position should be left behind")
+}
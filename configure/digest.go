@@ -0,0 +1,128 @@
+package configure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+/*
+What a part that a pipeline uses resolves to, with the value of each of its
+options as json
+*/
+type partDigest struct {
+	Attribute string
+	Ref       string
+	Retry     *Retry
+	Buffer    *Buffer
+	RateLimit *RateLimit
+	Options   map[string]string
+}
+
+/*
+The tokens of expr, without comments or newlines, so that only changes to what
+it says change what's returned
+*/
+func expressionTokens(literal []byte, expr hcl.Expression) string {
+	tokens, _ := hclsyntax.LexExpression(expr.Range().SliceBytes(literal), "", hcl.InitialPos)
+	kept := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenComment, hclsyntax.TokenNewline, hclsyntax.TokenEOF:
+		default:
+			kept = append(kept, string(token.Bytes))
+		}
+	}
+
+	return strings.Join(kept, " ")
+}
+
+/*
+The value of each option of body as json. Options that can't be evaluated with
+evalCtx, like those that refer to msg, are kept as their expressions
+*/
+func optionValues(literal []byte, body hcl.Body, evalCtx *hcl.EvalContext) map[string]string {
+	attributes, _ := body.JustAttributes()
+	options := make(map[string]string, len(attributes))
+	for name, attribute := range attributes {
+		value, diags := attribute.Expr.Value(evalCtx)
+		if !diags.HasErrors() && value.IsWhollyKnown() {
+			if encoded, err := ctyjson.Marshal(value, value.Type()); err == nil {
+				options[name] = string(encoded)
+				continue
+			}
+		}
+
+		options[name] = expressionTokens(literal, attribute.Expr)
+	}
+
+	return options
+}
+
+/*
+Set the Digest of each pipeline to a hash of what it resolves to: its own
+settings, the kind, name, blocks and option values of every resource it uses,
+and the plugins of the literal. Comments, spacing and values that a pipeline
+doesn't end up using don't change its digest, so a pipeline that would build the
+same gives the same digest
+*/
+func digestPipelines(filename string, literal []byte, evalCtx *hcl.EvalContext, pipelines map[string]*Pipeline) error {
+	plugins, diags := ParsePluginsDesc(filename, literal)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	for _, pipeline := range pipelines {
+		settings := *pipeline
+		settings.Digest, settings.Schedule = "", nil
+		settings.RemoteProducer, settings.RemoteTransformer, settings.RemoteConsumer = nil, nil, nil
+		settings.Producers, settings.Consumers, settings.Transformers = nil, nil, nil
+		settings.DeadLetter, settings.Routes = nil, nil
+
+		schedule := ""
+		if pipeline.Schedule != nil {
+			schedule = pipeline.Schedule.String()
+		}
+
+		routes := make([]string, len(pipeline.Routes))
+		for index, route := range pipeline.Routes {
+			if route.When != nil {
+				routes[index] = expressionTokens(literal, route.When)
+			}
+		}
+
+		uses := pipeline.Uses()
+		parts := make([]*partDigest, len(uses))
+		for index, use := range uses {
+			parts[index] = &partDigest{
+				Attribute: use.Attribute,
+				Ref:       use.Ref(),
+				Retry:     use.Part.Retry,
+				Buffer:    use.Part.Buffer,
+				RateLimit: use.Part.RateLimit,
+				Options:   optionValues(literal, use.Part.Options, evalCtx),
+			}
+		}
+
+		encoded, err := json.Marshal(struct {
+			Plugins  []PluginDesc
+			Settings Pipeline
+			Schedule string
+			Routes   []string
+			Parts    []*partDigest
+		}{plugins, settings, schedule, routes, parts})
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(encoded)
+		pipeline.Digest = hex.EncodeToString(hash[:])
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package configure
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_digestPipelines(test *testing.T) {
+	literal := `
+	value {
+		greeting = "hello"
+	}
+	produce "test" "p" {}
+	consume "test" "c" {}
+	transform "test" "t" {
+		greeting = value.greeting
+	}
+	pipeline "a" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+	}
+	pipeline "b" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = [transform.test.t]
+	}
+	`
+
+	digests := func(literal string) (string, string) {
+		configs, _, err := Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatalf("test-digest-pipelines: %s", err)
+		}
+
+		return configs["a"].Digest, configs["b"].Digest
+	}
+
+	a, b := digests(literal)
+	assert.NotEqual(test, a, b)
+
+	againA, againB := digests(literal)
+	assert.Equal(test, a, againA)
+	assert.Equal(test, b, againB)
+
+	changedA, changedB := digests(strings.Replace(literal, "greeting = value.greeting", `greeting = "hi"`, 1))
+	assert.Equal(test, a, changedA, "a doesn't use transform.test.t")
+	assert.NotEqual(test, b, changedB)
+
+	changedA, changedB = digests(strings.Replace(literal, `"hello"`, `"hi"`, 1))
+	assert.Equal(test, a, changedA, "a doesn't use value.greeting")
+	assert.NotEqual(test, b, changedB)
+
+	changedA, changedB = digests(strings.Replace(literal, `"hello"`, `"hi"`, 1) + `
+	transform "test" "unused" {}
+	`)
+	assert.Equal(test, a, changedA, "neither pipeline uses transform.test.unused")
+	assert.NotEqual(test, b, changedB)
+
+	reformatted := strings.NewReplacer(
+		"transform = []", "transform = [] # nothing to do",
+		"greeting = value.greeting", "// from the values block\n\t\tgreeting    =    value.greeting",
+		"\t\tconsume = [consume.test.c]", "\t\tconsume = [\n\t\t\tconsume.test.c,\n\t\t]",
+	).Replace(literal)
+	assert.NotEqual(test, literal, reformatted)
+	changedA, changedB = digests(reformatted)
+	assert.Equal(test, a, changedA, "comments and spacing don't change a")
+	assert.Equal(test, b, changedB, "comments and spacing don't change b")
+}
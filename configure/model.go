@@ -1,7 +1,11 @@
 package configure
 
 import (
+	"time"
+
+	"github.com/gastrodon/psyduck/schedule"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
 )
 
 /*
@@ -12,9 +16,15 @@ import (
 */
 
 type pipelinePart struct {
-	Kind    string   `hcl:"kind,label" cty:"kind"`
-	Name    string   `hcl:"name,label" cty:"kind"`
-	Options hcl.Body `hcl:",remain"`
+	Kind           string          `hcl:"kind,label" cty:"kind"`
+	Name           string          `hcl:"name,label" cty:"kind"`
+	RetryBlock     *retryBlock     `hcl:"retry,block"`
+	BufferBlock    *bufferBlock    `hcl:"buffer,block"`
+	RateLimitBlock *rateLimitBlock `hcl:"rate-limit,block"`
+	Options        hcl.Body        `hcl:",remain"`
+	Retry          *Retry
+	Buffer         *Buffer
+	RateLimit      *RateLimit
 }
 
 type pipelineParts struct {
@@ -23,21 +33,232 @@ type pipelineParts struct {
 	Transformers []*pipelinePart `hcl:"transform,block"`
 }
 
+type retryBlock struct {
+	Attempts       *int     `hcl:"attempts,optional" cty:"attempts"`
+	InitialBackoff *string  `hcl:"initial-backoff,optional" cty:"initial-backoff"`
+	MaxBackoff     *string  `hcl:"max-backoff,optional" cty:"max-backoff"`
+	Jitter         *float64 `hcl:"jitter,optional" cty:"jitter"`
+}
+
+type bufferBlock struct {
+	Size     *int    `hcl:"size,optional" cty:"size"`
+	Overflow *string `hcl:"overflow,optional" cty:"overflow"`
+	SpillDir *string `hcl:"spill-dir,optional" cty:"spill-dir"`
+}
+
+type rateLimitBlock struct {
+	PerSecond *float64 `hcl:"per-second,optional" cty:"per-second"`
+	Burst     *int     `hcl:"burst,optional" cty:"burst"`
+}
+
+type checkpointBlock struct {
+	Interval *string `cty:"interval"`
+	Dir      *string `cty:"dir"`
+}
+
+type routeBlock struct {
+	When      cty.Value `cty:"when"`
+	Consumers []string  `cty:"consume"`
+	condition hcl.Expression
+}
+
+type batchBlock struct {
+	Size   *int    `cty:"size"`
+	Linger *string `cty:"linger"`
+	Format *string `cty:"format"`
+}
+
 type pipelineBlock struct {
-	RemoteProducer *string  `cty:"produce-from"`
-	Producers      []string `cty:"produce"`
-	Consumers      []string `cty:"consume"`
-	Transformers   []string `cty:"transform"`
-	StopAfter      *int     `cty:"stop-after"`
-	ExitOnError    *bool    `cty:"exit-on-error"`
+	RemoteProducer *string           `cty:"produce-from"`
+	RemoteTimeout  *string           `cty:"produce-from-timeout"`
+	RemoteMode     *string           `cty:"produce-from-mode"`
+	Producers      []string          `cty:"produce"`
+	Consumers      []string          `cty:"consume"`
+	Transformers   []string          `cty:"transform"`
+	StopAfter      *int              `cty:"stop-after"`
+	MaxDuration    *string           `cty:"max-duration"`
+	MaxErrors      *int              `cty:"max-errors"`
+	DrainTimeout   *string           `cty:"drain-timeout"`
+	ExitOnError    *bool             `cty:"exit-on-error"`
+	Workers        *int              `cty:"workers"`
+	Ordered        *bool             `cty:"ordered"`
+	Batch          *batchBlock       `cty:"batch"`
+	DeadLetter     *string           `cty:"dead-letter"`
+	Retry          *retryBlock       `cty:"retry"`
+	Routes         []*routeBlock     `cty:"route"`
+	Buffer         *bufferBlock      `cty:"buffer"`
+	Checkpoint     *checkpointBlock  `cty:"checkpoint"`
+	Headers        map[string]string `cty:"headers"`
+	Envelope       *bool             `cty:"envelope"`
+	RateLimit      *rateLimitBlock   `cty:"rate-limit"`
+	Schedule       *string           `cty:"schedule"`
+	Overlap        *string           `cty:"overlap"`
+
+	RemoteTransformer      *string `cty:"transform-from"`
+	RemoteTransformTimeout *string `cty:"transform-from-timeout"`
+	RemoteConsumer         *string `cty:"consume-from"`
+	RemoteConsumeTimeout   *string `cty:"consume-from-timeout"`
+
+	body hcl.Body
 }
 
 type Pipeline struct {
 	Name           string
+	Digest         string
 	RemoteProducer *pipelinePart
+	RemoteMode     string
+	RemoteTimeout  time.Duration
 	Producers      []*pipelinePart
 	Consumers      []*pipelinePart
 	Transformers   []*pipelinePart
 	StopAfter      int
+	MaxDuration    time.Duration
+	MaxErrors      int
+	DrainTimeout   time.Duration
 	ExitOnError    bool
+	Workers        int
+	Ordered        bool
+	Batch          *Batch
+	DeadLetter     *pipelinePart
+	Retry          *Retry
+	Routes         []*Route
+	Buffer         *Buffer
+	Checkpoint     *Checkpoint
+	Headers        map[string]string
+	Envelope       bool
+	RateLimit      *RateLimit
+	Schedule       *schedule.Schedule
+	Overlap        string
+
+	// transform and consume blocks sent by these are added to Transformers and
+	// Consumers when the pipeline's built
+	RemoteTransformer      *pipelinePart
+	RemoteTransformTimeout time.Duration
+	RemoteConsumer         *pipelinePart
+	RemoteConsumeTimeout   time.Duration
+
+	// where resources keep what they need between runs, .psyduck/state/<pipeline>
+	StateDir string
+}
+
+/*
+A resource that a pipeline uses, along with the namespace it's from and the
+attribute of the pipeline that names it, like produce or dead-letter
+*/
+type Use struct {
+	Namespace string
+	Attribute string
+	Part      *pipelinePart
+}
+
+func (use *Use) Ref() string {
+	return name(use.Namespace, use.Part)
+}
+
+/*
+Every resource that pipeline uses, in the order they're named by its attributes
+*/
+func (pipeline *Pipeline) Uses() []*Use {
+	uses := make([]*Use, 0)
+	add := func(namespace, attribute string, parts ...*pipelinePart) {
+		for _, part := range parts {
+			if part != nil {
+				uses = append(uses, &Use{Namespace: namespace, Attribute: attribute, Part: part})
+			}
+		}
+	}
+
+	add(NAMESPACE_PRODUCE, "produce-from", pipeline.RemoteProducer)
+	add(NAMESPACE_PRODUCE, "transform-from", pipeline.RemoteTransformer)
+	add(NAMESPACE_PRODUCE, "consume-from", pipeline.RemoteConsumer)
+	add(NAMESPACE_PRODUCE, "produce", pipeline.Producers...)
+	add(NAMESPACE_TRANSFORM, "transform", pipeline.Transformers...)
+	add(NAMESPACE_CONSUME, "consume", pipeline.Consumers...)
+	for _, route := range pipeline.Routes {
+		add(NAMESPACE_CONSUME, "route", route.Consumers...)
+	}
+
+	add(NAMESPACE_CONSUME, "dead-letter", pipeline.DeadLetter)
+	return uses
+}
+
+/*
+Consumers that get only the messages for which When is true, with msg.* being
+the decoded message and meta.* what's known about it. A Route without When is
+the default, getting whatever no other route did
+*/
+type Route struct {
+	When      hcl.Expression
+	Consumers []*pipelinePart
+}
+
+const (
+	BATCH_FORMAT_JSON  = "json"
+	BATCH_FORMAT_LINES = "lines"
+
+	OVERFLOW_BLOCK       = "block"
+	OVERFLOW_DROP_NEWEST = "drop-newest"
+	OVERFLOW_DROP_OLDEST = "drop-oldest"
+	OVERFLOW_SPILL       = "spill-to-disk"
+
+	OVERLAP_SKIP  = "skip"
+	OVERLAP_QUEUE = "queue"
+	OVERLAP_ALLOW = "allow"
+
+	REMOTE_ONCE       = "once"
+	REMOTE_CONCURRENT = "concurrent"
+	REMOTE_SEQUENTIAL = "sequential"
+)
+
+/*
+Try a failing transformer up to Attempts times in total, waiting between each
+try. Waits start at InitialBackoff and double up to MaxBackoff, with up to a
+Jitter fraction of each wait randomly taken off
+*/
+type Retry struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64
+}
+
+/*
+Collect messages into batches of up to Size, flushing early once the oldest has
+waited Linger. A zero Size or Linger disables that trigger
+*/
+type Batch struct {
+	Size   int
+	Linger time.Duration
+	Format string
+}
+
+/*
+Hold up to Size messages for a consumer that isn't keeping up. What happens to
+messages past that is decided by Overflow, and spilled messages are written
+to a file in SpillDir ( or the system temp dir if empty )
+*/
+type Buffer struct {
+	Size     int
+	Overflow string
+	SpillDir string
+}
+
+/*
+Let at most PerSecond messages through each second on average, and at most
+Burst of them at once
+*/
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+/*
+Keep where producers are, so that a later run resumes from there. Positions are
+saved to Dir every Interval, which is .psyduck/state/<pipeline> unless set. A
+position is only saved once every message up to it has been handed to each
+consumer it went to, past any buffer or batch in front of them
+*/
+type Checkpoint struct {
+	Interval time.Duration
+	Dir      string
 }
@@ -2,10 +2,16 @@ package configure
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/gastrodon/psyduck/schedule"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/gocty"
 )
@@ -25,36 +31,294 @@ var pipelineBlockSpec = &hcldec.BlockObjectSpec{
 			Type:     cty.String,
 			Required: false,
 		},
+		"produce-from-timeout": &hcldec.AttrSpec{
+			Name:     "produce-from-timeout",
+			Type:     cty.String,
+			Required: false,
+		},
+		"produce-from-mode": &hcldec.AttrSpec{
+			Name:     "produce-from-mode",
+			Type:     cty.String,
+			Required: false,
+		},
 		"consume": &hcldec.AttrSpec{
 			Name:     "consume",
 			Type:     cty.List(cty.String),
-			Required: true,
+			Required: false,
+		},
+		"consume-from": &hcldec.AttrSpec{
+			Name:     "consume-from",
+			Type:     cty.String,
+			Required: false,
+		},
+		"consume-from-timeout": &hcldec.AttrSpec{
+			Name:     "consume-from-timeout",
+			Type:     cty.String,
+			Required: false,
 		},
 		"transform": &hcldec.AttrSpec{
 			Name:     "transform",
 			Type:     cty.List(cty.String),
 			Required: true,
 		},
+		"transform-from": &hcldec.AttrSpec{
+			Name:     "transform-from",
+			Type:     cty.String,
+			Required: false,
+		},
+		"transform-from-timeout": &hcldec.AttrSpec{
+			Name:     "transform-from-timeout",
+			Type:     cty.String,
+			Required: false,
+		},
 		"stop-after": &hcldec.AttrSpec{
 			Name:     "stop-after",
 			Type:     cty.Number,
 			Required: false,
 		},
+		"max-duration": &hcldec.AttrSpec{
+			Name:     "max-duration",
+			Type:     cty.String,
+			Required: false,
+		},
+		"max-errors": &hcldec.AttrSpec{
+			Name:     "max-errors",
+			Type:     cty.Number,
+			Required: false,
+		},
+		"drain-timeout": &hcldec.AttrSpec{
+			Name:     "drain-timeout",
+			Type:     cty.String,
+			Required: false,
+		},
+		"workers": &hcldec.AttrSpec{
+			Name:     "workers",
+			Type:     cty.Number,
+			Required: false,
+		},
+		"ordered": &hcldec.AttrSpec{
+			Name:     "ordered",
+			Type:     cty.Bool,
+			Required: false,
+		},
+		"headers": &hcldec.AttrSpec{
+			Name:     "headers",
+			Type:     cty.Map(cty.String),
+			Required: false,
+		},
+		"envelope": &hcldec.AttrSpec{
+			Name:     "envelope",
+			Type:     cty.Bool,
+			Required: false,
+		},
+		"schedule": &hcldec.AttrSpec{
+			Name:     "schedule",
+			Type:     cty.String,
+			Required: false,
+		},
+		"overlap": &hcldec.AttrSpec{
+			Name:     "overlap",
+			Type:     cty.String,
+			Required: false,
+		},
+		"dead-letter": &hcldec.AttrSpec{
+			Name:     "dead-letter",
+			Type:     cty.String,
+			Required: false,
+		},
+		"retry": &hcldec.BlockSpec{
+			TypeName: "retry",
+			Required: false,
+			Nested: hcldec.ObjectSpec{
+				"attempts": &hcldec.AttrSpec{
+					Name:     "attempts",
+					Type:     cty.Number,
+					Required: false,
+				},
+				"initial-backoff": &hcldec.AttrSpec{
+					Name:     "initial-backoff",
+					Type:     cty.String,
+					Required: false,
+				},
+				"max-backoff": &hcldec.AttrSpec{
+					Name:     "max-backoff",
+					Type:     cty.String,
+					Required: false,
+				},
+				"jitter": &hcldec.AttrSpec{
+					Name:     "jitter",
+					Type:     cty.Number,
+					Required: false,
+				},
+			},
+		},
+		"buffer": &hcldec.BlockSpec{
+			TypeName: "buffer",
+			Required: false,
+			Nested: hcldec.ObjectSpec{
+				"size": &hcldec.AttrSpec{
+					Name:     "size",
+					Type:     cty.Number,
+					Required: false,
+				},
+				"overflow": &hcldec.AttrSpec{
+					Name:     "overflow",
+					Type:     cty.String,
+					Required: false,
+				},
+				"spill-dir": &hcldec.AttrSpec{
+					Name:     "spill-dir",
+					Type:     cty.String,
+					Required: false,
+				},
+			},
+		},
+		"rate-limit": &hcldec.BlockSpec{
+			TypeName: "rate-limit",
+			Required: false,
+			Nested: hcldec.ObjectSpec{
+				"per-second": &hcldec.AttrSpec{
+					Name:     "per-second",
+					Type:     cty.Number,
+					Required: true,
+				},
+				"burst": &hcldec.AttrSpec{
+					Name:     "burst",
+					Type:     cty.Number,
+					Required: false,
+				},
+			},
+		},
+		"checkpoint": &hcldec.BlockSpec{
+			TypeName: "checkpoint",
+			Required: false,
+			Nested: hcldec.ObjectSpec{
+				"interval": &hcldec.AttrSpec{
+					Name:     "interval",
+					Type:     cty.String,
+					Required: false,
+				},
+				"dir": &hcldec.AttrSpec{
+					Name:     "dir",
+					Type:     cty.String,
+					Required: false,
+				},
+			},
+		},
+		"route": &hcldec.BlockListSpec{
+			TypeName: "route",
+			Nested: hcldec.ObjectSpec{
+				"when": &hcldec.AttrSpec{
+					Name:     "when",
+					Type:     cty.DynamicPseudoType,
+					Required: false,
+				},
+				"consume": &hcldec.AttrSpec{
+					Name:     "consume",
+					Type:     cty.List(cty.String),
+					Required: true,
+				},
+			},
+		},
+		"batch": &hcldec.BlockSpec{
+			TypeName: "batch",
+			Required: false,
+			Nested: hcldec.ObjectSpec{
+				"size": &hcldec.AttrSpec{
+					Name:     "size",
+					Type:     cty.Number,
+					Required: false,
+				},
+				"linger": &hcldec.AttrSpec{
+					Name:     "linger",
+					Type:     cty.String,
+					Required: false,
+				},
+				"format": &hcldec.AttrSpec{
+					Name:     "format",
+					Type:     cty.String,
+					Required: false,
+				},
+			},
+		},
 	},
 }
 
-func lookupRefSlice(refs []string, lookup map[string]*pipelinePart) ([]*pipelinePart, error) {
-	resources := make([]*pipelinePart, len(refs))
+/*
+Where body is, if it's known
+*/
+func bodyRange(body hcl.Body) *hcl.Range {
+	if body == nil {
+		return nil
+	}
+
+	return body.MissingItemRange().Ptr()
+}
+
+/*
+Where attribute is set in body, or where body is if it isn't
+*/
+func attributeRange(body hcl.Body, attribute string) *hcl.Range {
+	if syntax, ok := body.(*hclsyntax.Body); ok {
+		if attr, ok := syntax.Attributes[attribute]; ok {
+			return attr.SrcRange.Ptr()
+		}
+	}
+
+	return bodyRange(body)
+}
+
+/*
+The body of the nth block of blockType in body, or body itself if there's no
+such block
+*/
+func blockBody(body hcl.Body, blockType string, index int) hcl.Body {
+	if syntax, ok := body.(*hclsyntax.Body); ok {
+		for _, block := range syntax.Blocks {
+			if block.Type != blockType {
+				continue
+			}
+
+			if index == 0 {
+				return block.Body
+			}
+
+			index--
+		}
+	}
+
+	return body
+}
+
+/*
+An error about the value of attribute, which is set in body
+*/
+func invalidAttribute(body hcl.Body, attribute string, format string, args ...interface{}) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid value for " + attribute,
+		Detail:   fmt.Sprintf(format, args...),
+		Subject:  attributeRange(body, attribute),
+	}
+}
+
+func lookupRefSlice(body hcl.Body, attribute string, refs []string, lookup map[string]*pipelinePart) ([]*pipelinePart, hcl.Diagnostics) {
+	resources, diags := make([]*pipelinePart, len(refs)), make(hcl.Diagnostics, 0)
 
 	for index, ref := range refs {
 		if resource, ok := lookup[ref]; !ok {
-			return nil, fmt.Errorf("can't find a resource %s", ref)
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unknown resource",
+				Detail:   fmt.Sprintf("can't find a resource %s", ref),
+				Subject:  attributeRange(body, attribute),
+			})
 		} else {
 			resources[index] = resource
 		}
 	}
 
-	return resources, nil
+	return resources, diags
 }
 
 func derefOr[T any](v *T, d T) T {
@@ -65,54 +329,503 @@ func derefOr[T any](v *T, d T) T {
 	return d
 }
 
-func lookupPipelines(refs map[string]*pipelineBlock, lookup map[string]*pipelinePart) (map[string]*Pipeline, error) {
-	pipelines := make(map[string]*Pipeline, len(refs))
-	for name, ref := range refs {
-		consumers, err := lookupRefSlice(ref.Consumers, lookup)
-		if err != nil {
-			return nil, fmt.Errorf("failed looking up consumer ref slice: %s", err)
+func parseDurationOr(v *string, d time.Duration) (time.Duration, error) {
+	if v == nil {
+		return d, nil
+	}
+
+	return time.ParseDuration(*v)
+}
+
+/*
+Parse the duration that attribute of body is set to, or d if it isn't set
+*/
+func lookupDuration(body hcl.Body, attribute string, v *string, d time.Duration) (time.Duration, hcl.Diagnostics) {
+	duration, err := parseDurationOr(v, d)
+	if err != nil {
+		return 0, hcl.Diagnostics{invalidAttribute(body, attribute, "failed parsing %s: %s", attribute, err)}
+	}
+
+	return duration, nil
+}
+
+func lookupRetry(ref *retryBlock, body hcl.Body) (*Retry, hcl.Diagnostics) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	initialBackoff, diags := lookupDuration(body, "initial-backoff", ref.InitialBackoff, 100*time.Millisecond)
+	maxBackoff, more := lookupDuration(body, "max-backoff", ref.MaxBackoff, 10*time.Second)
+	diags = diags.Extend(more)
+
+	retry := &Retry{
+		Attempts:       derefOr(ref.Attempts, 3),
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+		Jitter:         derefOr(ref.Jitter, 0),
+	}
+
+	if retry.Attempts < 1 {
+		diags = diags.Append(invalidAttribute(body, "attempts", "attempts must be at least 1, got %d", retry.Attempts))
+	}
+
+	if !diags.HasErrors() && (retry.InitialBackoff < 0 || retry.MaxBackoff < retry.InitialBackoff) {
+		diags = diags.Append(invalidAttribute(body, "max-backoff", "backoff must be positive, with max-backoff at least initial-backoff"))
+	}
+
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		diags = diags.Append(invalidAttribute(body, "jitter", "jitter must be between 0 and 1, got %f", retry.Jitter))
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return retry, nil
+}
+
+func lookupBuffer(ref *bufferBlock, body hcl.Body) (*Buffer, hcl.Diagnostics) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	buffer := &Buffer{
+		Size:     derefOr(ref.Size, 100),
+		Overflow: derefOr(ref.Overflow, OVERFLOW_BLOCK),
+		SpillDir: derefOr(ref.SpillDir, ""),
+	}
+
+	diags := make(hcl.Diagnostics, 0)
+	if buffer.Size < 1 {
+		diags = diags.Append(invalidAttribute(body, "size", "size must be at least 1, got %d", buffer.Size))
+	}
+
+	switch buffer.Overflow {
+	case OVERFLOW_BLOCK, OVERFLOW_DROP_NEWEST, OVERFLOW_DROP_OLDEST, OVERFLOW_SPILL:
+	default:
+		diags = diags.Append(invalidAttribute(body, "overflow", "unknown overflow %s", buffer.Overflow))
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return buffer, nil
+}
+
+func lookupRateLimit(ref *rateLimitBlock, body hcl.Body) (*RateLimit, hcl.Diagnostics) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	if ref.PerSecond == nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required argument",
+			Detail:   "per-second is required",
+			Subject:  bodyRange(body),
+		}}
+	}
+
+	limit, diags := &RateLimit{PerSecond: *ref.PerSecond, Burst: derefOr(ref.Burst, 1)}, make(hcl.Diagnostics, 0)
+	if limit.PerSecond <= 0 {
+		diags = diags.Append(invalidAttribute(body, "per-second", "per-second must be positive, got %g", limit.PerSecond))
+	}
+
+	if limit.Burst < 1 {
+		diags = diags.Append(invalidAttribute(body, "burst", "burst must be at least 1, got %d", limit.Burst))
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return limit, nil
+}
+
+func lookupSchedule(expression, overlap *string, body hcl.Body) (*schedule.Schedule, string, hcl.Diagnostics) {
+	if expression == nil {
+		if overlap != nil {
+			return nil, "", hcl.Diagnostics{invalidAttribute(body, "overlap", "overlap needs a schedule")}
 		}
 
-		transformers, err := lookupRefSlice(ref.Transformers, lookup)
-		if err != nil {
-			return nil, fmt.Errorf("failed looking up transformer ref slice: %s", err)
+		return nil, "", nil
+	}
+
+	diags := make(hcl.Diagnostics, 0)
+	parsed, err := schedule.Parse(*expression)
+	if err != nil {
+		diags = diags.Append(invalidAttribute(body, "schedule", "%s", err))
+	}
+
+	switch policy := derefOr(overlap, OVERLAP_SKIP); policy {
+	case OVERLAP_SKIP, OVERLAP_QUEUE, OVERLAP_ALLOW:
+		if diags.HasErrors() {
+			return nil, "", diags
 		}
 
-		if ref.RemoteProducer != nil {
-			r, ok := lookup[*ref.RemoteProducer]
-			if !ok {
-				return nil, fmt.Errorf("can't find a remote provider %s", *ref.RemoteProducer)
-			}
+		return parsed, policy, nil
+	default:
+		return nil, "", diags.Append(invalidAttribute(body, "overlap", "unknown overlap %s", policy))
+	}
+}
+
+/*
+How a meta-producer is read. Once, its first message is waited on for up to
+timeout, which is 10s by default. Streaming, configs are read until none has come
+for timeout, which by default is never
+*/
+func lookupRemote(mode, timeout *string, body hcl.Body) (string, time.Duration, hcl.Diagnostics) {
+	policy := derefOr(mode, REMOTE_ONCE)
+	wait := time.Duration(0)
+	switch policy {
+	case REMOTE_ONCE:
+		wait = 10 * time.Second
+	case REMOTE_CONCURRENT, REMOTE_SEQUENTIAL:
+	default:
+		return "", 0, hcl.Diagnostics{invalidAttribute(body, "produce-from-mode", "unknown produce-from-mode %s", policy)}
+	}
+
+	wait, diags := lookupDuration(body, "produce-from-timeout", timeout, wait)
+	if diags.HasErrors() {
+		return "", 0, diags
+	}
+
+	if wait < 0 {
+		return "", 0, hcl.Diagnostics{invalidAttribute(body, "produce-from-timeout", "produce-from-timeout can't be negative, got %s", wait)}
+	}
+
+	return policy, wait, nil
+}
+
+/*
+Find the meta-producer that attribute of a pipeline names, along with how long
+to wait on its config, which is 10s by default
+*/
+func lookupRemotePart(attribute string, ref, timeout *string, lookup map[string]*pipelinePart, body hcl.Body) (*pipelinePart, time.Duration, hcl.Diagnostics) {
+	if ref == nil {
+		if timeout != nil {
+			return nil, 0, hcl.Diagnostics{invalidAttribute(body, attribute+"-timeout", "%s-timeout needs %s", attribute, attribute)}
+		}
+
+		return nil, 0, nil
+	}
+
+	if !strings.HasPrefix(*ref, NAMESPACE_PRODUCE+".") {
+		return nil, 0, hcl.Diagnostics{invalidAttribute(body, attribute, "%s must be a producer, got %s", attribute, *ref)}
+	}
+
+	diags := make(hcl.Diagnostics, 0)
+	part, ok := lookup[*ref]
+	if !ok {
+		diags = diags.Append(invalidAttribute(body, attribute, "can't find a meta-producer %s", *ref))
+	}
+
+	wait, more := lookupDuration(body, attribute+"-timeout", timeout, 10*time.Second)
+	diags = diags.Extend(more)
+	if wait < 0 {
+		diags = diags.Append(invalidAttribute(body, attribute+"-timeout", "%s-timeout can't be negative, got %s", attribute, wait))
+	}
+
+	if diags.HasErrors() {
+		return nil, 0, diags
+	}
+
+	return part, wait, nil
+}
+
+func lookupCheckpoint(ref *checkpointBlock, name string, body hcl.Body) (*Checkpoint, hcl.Diagnostics) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	interval, diags := lookupDuration(body, "interval", ref.Interval, 5*time.Second)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if interval <= 0 {
+		return nil, hcl.Diagnostics{invalidAttribute(body, "interval", "interval must be positive, got %s", interval)}
+	}
+
+	return &Checkpoint{Interval: interval, Dir: derefOr(ref.Dir, filepath.Join(".psyduck", "state", name))}, nil
+}
 
-			pipelines[name] = &Pipeline{
-				Name:           name,
-				RemoteProducer: r,
-				Producers:      nil,
-				Consumers:      consumers,
-				Transformers:   transformers,
-				ExitOnError:    derefOr(ref.ExitOnError, false),
-				StopAfter:      derefOr(ref.StopAfter, 0),
+func lookupBatch(ref *batchBlock, body hcl.Body) (*Batch, hcl.Diagnostics) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	linger, diags := lookupDuration(body, "linger", ref.Linger, 0)
+	batch := &Batch{
+		Size:   derefOr(ref.Size, 0),
+		Linger: linger,
+		Format: derefOr(ref.Format, BATCH_FORMAT_JSON),
+	}
+
+	if batch.Size < 0 {
+		diags = diags.Append(invalidAttribute(body, "size", "size can't be negative, got %d", batch.Size))
+	}
+
+	if batch.Linger < 0 {
+		diags = diags.Append(invalidAttribute(body, "linger", "linger can't be negative, got %s", batch.Linger))
+	}
+
+	if !diags.HasErrors() && batch.Size == 0 && batch.Linger == 0 {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required argument",
+			Detail:   "one of size or linger is required",
+			Subject:  bodyRange(body),
+		})
+	}
+
+	switch batch.Format {
+	case BATCH_FORMAT_JSON, BATCH_FORMAT_LINES:
+	default:
+		diags = diags.Append(invalidAttribute(body, "format", "unknown format %s", batch.Format))
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return batch, nil
+}
+
+func lookupRoutes(refs []*routeBlock, lookup map[string]*pipelinePart, body hcl.Body) ([]*Route, hcl.Diagnostics) {
+	routes, fallback, diags := make([]*Route, len(refs)), false, make(hcl.Diagnostics, 0)
+	for index, ref := range refs {
+		route := blockBody(body, "route", index)
+		consumers, more := lookupRefSlice(route, "consume", ref.Consumers, lookup)
+		diags = diags.Extend(more)
+
+		if ref.condition == nil {
+			if fallback {
+				diags = diags.Append(&hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate fallback route",
+					Detail:   "only one route may leave out when",
+					Subject:  bodyRange(route),
+				})
 			}
+
+			fallback = true
+		}
+
+		routes[index] = &Route{When: ref.condition, Consumers: consumers}
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return routes, nil
+}
+
+/*
+Look up every pipeline of refs, collecting every problem found in any of them
+rather than stopping at the first
+*/
+func lookupPipelines(refs map[string]*pipelineBlock, lookup map[string]*pipelinePart) (map[string]*Pipeline, hcl.Diagnostics) {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	pipelines, diags := make(map[string]*Pipeline, len(refs)), make(hcl.Diagnostics, 0)
+	for _, name := range names {
+		pipeline, more := lookupPipeline(name, refs[name], lookup)
+		diags = diags.Extend(more)
+		pipelines[name] = pipeline
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return pipelines, diags
+}
+
+func lookupPipeline(name string, ref *pipelineBlock, lookup map[string]*pipelinePart) (*Pipeline, hcl.Diagnostics) {
+	body := ref.body
+	consumers, diags := lookupRefSlice(body, "consume", ref.Consumers, lookup)
+
+	transformers, more := lookupRefSlice(body, "transform", ref.Transformers, lookup)
+	diags = diags.Extend(more)
+
+	maxDuration, more := lookupDuration(body, "max-duration", ref.MaxDuration, 0)
+	diags = diags.Extend(more)
+
+	drainTimeout, more := lookupDuration(body, "drain-timeout", ref.DrainTimeout, 0)
+	diags = diags.Extend(more)
+
+	batch, more := lookupBatch(ref.Batch, blockBody(body, "batch", 0))
+	diags = diags.Extend(more)
+
+	retry, more := lookupRetry(ref.Retry, blockBody(body, "retry", 0))
+	diags = diags.Extend(more)
+
+	buffer, more := lookupBuffer(ref.Buffer, blockBody(body, "buffer", 0))
+	diags = diags.Extend(more)
+
+	rateLimit, more := lookupRateLimit(ref.RateLimit, blockBody(body, "rate-limit", 0))
+	diags = diags.Extend(more)
+
+	schedule, overlap, more := lookupSchedule(ref.Schedule, ref.Overlap, body)
+	diags = diags.Extend(more)
+
+	checkpoint, more := lookupCheckpoint(ref.Checkpoint, name, blockBody(body, "checkpoint", 0))
+	diags = diags.Extend(more)
+
+	remoteTransformer, remoteTransformTimeout, more := lookupRemotePart("transform-from", ref.RemoteTransformer, ref.RemoteTransformTimeout, lookup, body)
+	diags = diags.Extend(more)
+
+	remoteConsumer, remoteConsumeTimeout, more := lookupRemotePart("consume-from", ref.RemoteConsumer, ref.RemoteConsumeTimeout, lookup, body)
+	diags = diags.Extend(more)
+
+	if ref.Consumers == nil && len(ref.Routes) == 0 && ref.RemoteConsumer == nil {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required argument",
+			Detail:   fmt.Sprintf("%s needs consume, route, consume-from, or some of them", name),
+			Subject:  bodyRange(body),
+		})
+	}
+
+	routes, more := lookupRoutes(ref.Routes, lookup, body)
+	diags = diags.Extend(more)
+
+	if ref.Workers != nil && *ref.Workers < 1 {
+		diags = diags.Append(invalidAttribute(body, "workers", "workers of %s must be at least 1, got %d", name, *ref.Workers))
+	}
+
+	pipeline := &Pipeline{
+		Name:         name,
+		Consumers:    consumers,
+		Transformers: transformers,
+		ExitOnError:  derefOr(ref.ExitOnError, false),
+		StopAfter:    derefOr(ref.StopAfter, 0),
+		MaxDuration:  maxDuration,
+		MaxErrors:    derefOr(ref.MaxErrors, 0),
+		DrainTimeout: drainTimeout,
+		Workers:      derefOr(ref.Workers, 1),
+		Ordered:      derefOr(ref.Ordered, true),
+		Batch:        batch,
+		Retry:        retry,
+		Routes:       routes,
+		Buffer:       buffer,
+		Checkpoint:   checkpoint,
+		Headers:      ref.Headers,
+		Envelope:     derefOr(ref.Envelope, false),
+		RateLimit:    rateLimit,
+		Schedule:     schedule,
+		Overlap:      overlap,
+
+		RemoteTransformer:      remoteTransformer,
+		RemoteTransformTimeout: remoteTransformTimeout,
+		RemoteConsumer:         remoteConsumer,
+		RemoteConsumeTimeout:   remoteConsumeTimeout,
+
+		StateDir: filepath.Join(".psyduck", "state", name),
+	}
+
+	if ref.DeadLetter != nil {
+		if !strings.HasPrefix(*ref.DeadLetter, NAMESPACE_CONSUME+".") {
+			diags = diags.Append(invalidAttribute(body, "dead-letter", "dead-letter of %s must be a consumer, got %s", name, *ref.DeadLetter))
+		} else if deadLetter, ok := lookup[*ref.DeadLetter]; !ok {
+			diags = diags.Append(invalidAttribute(body, "dead-letter", "can't find a dead-letter consumer %s", *ref.DeadLetter))
+		} else {
+			pipeline.DeadLetter = deadLetter
+		}
+	}
+
+	if ref.RemoteProducer != nil {
+		if r, ok := lookup[*ref.RemoteProducer]; !ok {
+			diags = diags.Append(invalidAttribute(body, "produce-from", "can't find a remote provider %s", *ref.RemoteProducer))
 		} else {
-			producers, err := lookupRefSlice(ref.Producers, lookup)
-			if err != nil {
-				return nil, fmt.Errorf("can't find a provider ref slice: %s", err)
+			pipeline.RemoteProducer = r
+		}
+
+		mode, timeout, more := lookupRemote(ref.RemoteMode, ref.RemoteTimeout, body)
+		pipeline.RemoteMode, pipeline.RemoteTimeout = mode, timeout
+		diags = diags.Extend(more)
+	} else {
+		if ref.RemoteMode != nil || ref.RemoteTimeout != nil {
+			attribute := "produce-from-mode"
+			if ref.RemoteMode == nil {
+				attribute = "produce-from-timeout"
 			}
 
-			pipelines[name] = &Pipeline{
-				Name:           name,
-				RemoteProducer: nil,
-				Producers:      producers,
-				Consumers:      consumers,
-				Transformers:   transformers,
-				ExitOnError:    derefOr(ref.ExitOnError, false),
-				StopAfter:      derefOr(ref.StopAfter, 0),
+			diags = diags.Append(invalidAttribute(body, attribute, "produce-from-mode and produce-from-timeout of %s need produce-from", name))
+		}
+
+		producers, more := lookupRefSlice(body, "produce", ref.Producers, lookup)
+		pipeline.Producers = producers
+		diags = diags.Extend(more)
+	}
+
+	return pipeline, diags
+}
+
+/*
+Collect the when expression of every route block, by pipeline and in order. Routes
+without a when have a nil expression
+*/
+func loadRouteConditions(body hcl.Body) (map[string][]hcl.Expression, hcl.Diagnostics) {
+	content, _, diags := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "pipeline", LabelNames: []string{"name"}}},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	conditions := make(map[string][]hcl.Expression, len(content.Blocks))
+	for _, pipeline := range content.Blocks {
+		routes, _, diags := pipeline.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "route"}},
+		})
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		found := make([]hcl.Expression, len(routes.Blocks))
+		for index, route := range routes.Blocks {
+			attrs, diags := route.Body.JustAttributes()
+			if diags.HasErrors() {
+				return nil, diags
+			}
+
+			if when, ok := attrs["when"]; ok {
+				found[index] = when.Expr
 			}
 		}
 
+		conditions[pipeline.Labels[0]] = found
+	}
+
+	return conditions, nil
+}
+
+/*
+Collect the body of every pipeline block by name, so that problems found in one
+can point at where they are
+*/
+func loadPipelineBodies(body hcl.Body) (map[string]hcl.Body, hcl.Diagnostics) {
+	content, _, diags := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "pipeline", LabelNames: []string{"name"}}},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	bodies := make(map[string]hcl.Body, len(content.Blocks))
+	for _, pipeline := range content.Blocks {
+		bodies[pipeline.Labels[0]] = pipeline.Body
 	}
 
-	return pipelines, nil
+	return bodies, nil
 }
 
 func loadPipelines(filename string, literal []byte, evalCtx *hcl.EvalContext, lookup map[string]*pipelinePart) (map[string]*Pipeline, error) {
@@ -121,7 +834,21 @@ func loadPipelines(filename string, literal []byte, evalCtx *hcl.EvalContext, lo
 		return nil, diags
 	}
 
-	value, _, diags := hcldec.PartialDecode(file.Body, pipelineBlockSpec, evalCtx)
+	// route conditions are only known once there's a message, so msg is left unknown
+	// here and the expressions themselves are collected separately
+	decodeCtx := evalCtx.NewChild()
+	decodeCtx.Variables = map[string]cty.Value{NAMESPACE_MSG: cty.DynamicVal, NAMESPACE_META: cty.DynamicVal}
+	value, _, diags := hcldec.PartialDecode(file.Body, pipelineBlockSpec, decodeCtx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	conditions, diags := loadRouteConditions(file.Body)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	bodies, diags := loadPipelineBodies(file.Body)
 	if diags.HasErrors() {
 		return nil, diags
 	}
@@ -135,9 +862,18 @@ func loadPipelines(filename string, literal []byte, evalCtx *hcl.EvalContext, lo
 		if err := gocty.FromCtyValue(each, ref); err != nil {
 			return nil, fmt.Errorf("failed to decode cty value: %s", err)
 		} else {
+			for index, route := range ref.Routes {
+				route.condition = conditions[key.AsString()][index]
+			}
+
+			ref.body = bodies[key.AsString()]
 			refs[key.AsString()] = ref
 		}
 	}
 
-	return lookupPipelines(refs, lookup)
+	if pipelines, diags := lookupPipelines(refs, lookup); diags.HasErrors() {
+		return nil, diags
+	} else {
+		return pipelines, nil
+	}
 }
@@ -12,7 +12,11 @@ import (
 	"github.com/hashicorp/hcl/v2/hclparse"
 )
 
-func Partial(filename string, literal []byte, context *hcl.EvalContext) (*pipelineParts, hcl.Diagnostics) {
+/*
+Read the produce, consume and transform blocks of a literal that's not part of
+the workspace, like the config a meta-producer sends
+*/
+func Partial(filename string, literal []byte, context *hcl.EvalContext) (*pipelineParts, error) {
 	file, diags := hclparse.NewParser().ParseHCL(literal, filename)
 	if diags.HasErrors() {
 		return nil, diags
@@ -23,38 +27,63 @@ func Partial(filename string, literal []byte, context *hcl.EvalContext) (*pipeli
 		return nil, diags
 	}
 
+	for namespace, parts := range map[string][]*pipelinePart{
+		NAMESPACE_PRODUCE:   resources.Producers,
+		NAMESPACE_CONSUME:   resources.Consumers,
+		NAMESPACE_TRANSFORM: resources.Transformers,
+	} {
+		for _, part := range parts {
+			if diags := lookupPartBlocks(namespace, part); diags.HasErrors() {
+				return nil, fmt.Errorf("failed looking up %s.%s: %w", part.Kind, part.Name, diags)
+			}
+		}
+	}
+
 	return resources, nil
 }
 
 func Literal(filename string, literal []byte) (map[string]*Pipeline, *hcl.EvalContext, error) {
 	valuesContext, diags := makeEvalCtx(filename, literal)
 	if diags.HasErrors() {
-		return nil, nil, fmt.Errorf("failed to load values ctx: %s", diags.Error())
+		return nil, nil, fmt.Errorf("failed to load values ctx: %w", diags)
 	}
 
 	resourcesContext, err := loadResourcesContext(filename, literal)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load resources ctx: %s", err)
+		return nil, nil, fmt.Errorf("failed to load resources ctx: %w", err)
 	}
 
 	resourceLookup, err := loadResorceLookup(filename, literal, valuesContext)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load resources lookup: %s", err)
+		return nil, nil, fmt.Errorf("failed to load resources lookup: %w", err)
 	}
 
 	pipelines, err := loadPipelines(filename, literal, resourcesContext, resourceLookup)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load pipelines: %s", err)
+		return nil, nil, fmt.Errorf("failed to load pipelines: %w", err)
+	}
+
+	if err := digestPipelines(filename, literal, valuesContext, pipelines); err != nil {
+		return nil, nil, fmt.Errorf("failed to digest pipelines: %w", err)
 	}
 
 	return pipelines, valuesContext, nil
 }
 
 func ReadDirectory(directory string) ([]byte, error) {
-	literal := bytes.NewBuffer(nil)
+	literal, _, err := ReadDirectorySources(directory)
+	return literal, err
+}
+
+/*
+Read every .psy file of directory into one literal, like ReadDirectory, along
+with where in it each file is
+*/
+func ReadDirectorySources(directory string) ([]byte, *SourceMap, error) {
+	literal, sources := bytes.NewBuffer(nil), new(SourceMap)
 	paths, err := os.ReadDir(directory)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read files in %s: %s", directory, err)
+		return nil, nil, fmt.Errorf("failed to read files in %s: %s", directory, err)
 	}
 
 	for _, each := range paths {
@@ -62,12 +91,14 @@ func ReadDirectory(directory string) ([]byte, error) {
 			continue
 		}
 
-		if content, err := os.ReadFile(path.Join(directory, each.Name())); err != nil {
-			return nil, fmt.Errorf("failed reading %s: %s", each.Name(), err)
+		filename := path.Join(directory, each.Name())
+		if content, err := os.ReadFile(filename); err != nil {
+			return nil, nil, fmt.Errorf("failed reading %s: %s", each.Name(), err)
 		} else {
+			sources.files = append(sources.files, &sourceFile{name: filename, start: literal.Len(), content: content})
 			literal.Write(content)
 		}
 	}
 
-	return literal.Bytes(), nil
+	return literal.Bytes(), sources, nil
 }
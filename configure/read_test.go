@@ -1,8 +1,12 @@
 package configure
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -50,3 +54,515 @@ func TestLiteral(test *testing.T) {
 		}
 	}
 }
+
+func TestLiteral_limits(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		stop-after = 10
+		max-duration = "1m30s"
+		max-errors = 3
+		drain-timeout = "5s"
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-limits: %s", err)
+	}
+
+	assert.Equal(test, 10, configs["test"].StopAfter)
+	assert.Equal(test, 90*time.Second, configs["test"].MaxDuration)
+	assert.Equal(test, 3, configs["test"].MaxErrors)
+	assert.Equal(test, 5*time.Second, configs["test"].DrainTimeout)
+	assert.Equal(test, 1, configs["test"].Workers)
+	assert.True(test, configs["test"].Ordered)
+}
+
+func TestLiteral_workers(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		workers = 8
+		ordered = false
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-workers: %s", err)
+	}
+
+	assert.Equal(test, 8, configs["test"].Workers)
+	assert.False(test, configs["test"].Ordered)
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, "workers = 8", "workers = 0", 1))); err == nil {
+		test.Fatal("test-literal-workers: expected an error for 0 workers")
+	}
+}
+
+func TestLiteral_batch(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		batch {
+			size = 50
+			linger = "250ms"
+			format = "lines"
+		}
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-batch: %s", err)
+	}
+
+	assert.Equal(test, &Batch{Size: 50, Linger: 250 * time.Millisecond, Format: BATCH_FORMAT_LINES}, configs["test"].Batch)
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, `"lines"`, `"xml"`, 1))); err == nil {
+		test.Fatal("test-literal-batch: expected an error for an unknown format")
+	}
+}
+
+func TestLiteral_deadLetter(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	consume "test" "dlq" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		dead-letter = consume.test.dlq
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-dead-letter: %s", err)
+	}
+
+	assert.Equal(test, "dlq", configs["test"].DeadLetter.Name)
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, "consume.test.dlq", "produce.test.p", 1))); err == nil {
+		test.Fatal("test-literal-dead-letter: expected an error for a producer dead-letter")
+	}
+}
+
+func TestLiteral_retry(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	transform "test" "flaky" {
+		option = "kept for the plugin"
+		retry {
+			attempts = 5
+			initial-backoff = "10ms"
+			max-backoff = "1s"
+			jitter = 0.25
+		}
+	}
+	transform "test" "steady" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = [transform.test.flaky, transform.test.steady]
+		retry {
+			attempts = 2
+		}
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-retry: %s", err)
+	}
+
+	pipeline := configs["test"]
+	assert.Equal(test, &Retry{Attempts: 2, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second}, pipeline.Retry)
+	assert.Equal(test, &Retry{Attempts: 5, InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.25}, pipeline.Transformers[0].Retry)
+	assert.Nil(test, pipeline.Transformers[1].Retry)
+
+	content, _, diags := pipeline.Transformers[0].Options.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "option", Required: true}},
+	})
+	assert.False(test, diags.HasErrors(), "%s", diags)
+	assert.Contains(test, content.Attributes, "option")
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, "jitter = 0.25", "jitter = 2", 1))); err == nil {
+		test.Fatal("test-literal-retry: expected an error for jitter over 1")
+	}
+}
+
+func TestLiteral_route(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "all" {}
+	consume "test" "alerts" {}
+	consume "test" "rest" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.all]
+		transform = []
+		route {
+			when = msg.level == "error"
+			consume = [consume.test.alerts]
+		}
+		route {
+			consume = [consume.test.rest]
+		}
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-route: %s", err)
+	}
+
+	routes := configs["test"].Routes
+	assert.Len(test, routes, 2)
+	assert.NotNil(test, routes[0].When)
+	assert.Equal(test, "alerts", routes[0].Consumers[0].Name)
+	assert.Nil(test, routes[1].When)
+	assert.Equal(test, "rest", routes[1].Consumers[0].Name)
+
+	routesOnly := strings.Replace(literal, "consume = [consume.test.all]", "", 1)
+	if configs, _, err := Literal("test.psy", []byte(routesOnly)); err != nil {
+		test.Fatalf("test-literal-route: %s", err)
+	} else {
+		assert.Empty(test, configs["test"].Consumers)
+	}
+
+	twoDefaults := strings.Replace(literal, `when = msg.level == "error"`, "", 1)
+	if _, _, err := Literal("test.psy", []byte(twoDefaults)); err == nil {
+		test.Fatal("test-literal-route: expected an error for two default routes")
+	}
+
+	neither := `
+	produce "test" "p" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		transform = []
+	}
+	`
+	if _, _, err := Literal("test.psy", []byte(neither)); err == nil {
+		test.Fatal("test-literal-route: expected an error without consume or route")
+	}
+}
+
+func TestLiteral_buffer(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {
+		buffer {
+			size = 10
+			overflow = "drop-oldest"
+		}
+	}
+	consume "test" "d" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c, consume.test.d]
+		transform = []
+		buffer {
+			overflow = "spill-to-disk"
+			spill-dir = "/tmp/spill"
+		}
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-buffer: %s", err)
+	}
+
+	assert.Equal(test, &Buffer{Size: 10, Overflow: OVERFLOW_DROP_OLDEST}, configs["test"].Consumers[0].Buffer)
+	assert.Nil(test, configs["test"].Consumers[1].Buffer)
+	assert.Equal(test, &Buffer{Size: 100, Overflow: OVERFLOW_SPILL, SpillDir: "/tmp/spill"}, configs["test"].Buffer)
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, `"drop-oldest"`, `"drop-everything"`, 1))); err == nil {
+		test.Fatal("test-literal-buffer: expected an error for an unknown overflow")
+	}
+}
+
+func TestLiteral_rateLimit(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {
+		rate-limit {
+			per-second = 500
+			burst = 50
+		}
+	}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		rate-limit {
+			per-second = 0.5
+		}
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-rate-limit: %s", err)
+	}
+
+	assert.Equal(test, &RateLimit{PerSecond: 500, Burst: 50}, configs["test"].Consumers[0].RateLimit)
+	assert.Equal(test, &RateLimit{PerSecond: 0.5, Burst: 1}, configs["test"].RateLimit)
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, "burst = 50", "burst = 0", 1))); err == nil {
+		test.Fatal("test-literal-rate-limit: expected an error for a zero burst")
+	}
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, "per-second = 500", "", 1))); err == nil {
+		test.Fatal("test-literal-rate-limit: expected an error without per-second")
+	}
+}
+
+func TestLiteral_schedule(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		schedule = "*/5 * * * *"
+		overlap = "queue"
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-schedule: %s", err)
+	}
+
+	assert.Equal(test, "*/5 * * * *", configs["test"].Schedule.String())
+	assert.Equal(test, OVERLAP_QUEUE, configs["test"].Overlap)
+
+	for _, broken := range []string{
+		strings.Replace(literal, `"*/5 * * * *"`, `"every 5 minutes"`, 1),
+		strings.Replace(literal, `"queue"`, `"pile-up"`, 1),
+		strings.Replace(literal, `schedule = "*/5 * * * *"`, "", 1),
+	} {
+		if _, _, err := Literal("test.psy", []byte(broken)); err == nil {
+			test.Fatalf("test-literal-schedule: expected an error for %s", broken)
+		}
+	}
+}
+
+func TestLiteral_produceFrom(test *testing.T) {
+	literal := `
+	produce "test" "control" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce-from = produce.test.control
+		consume = [consume.test.c]
+		transform = []
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-produce-from: %s", err)
+	}
+
+	assert.Equal(test, REMOTE_ONCE, configs["test"].RemoteMode)
+	assert.Equal(test, 10*time.Second, configs["test"].RemoteTimeout)
+
+	streaming := strings.Replace(literal, "transform = []", `transform = []
+		produce-from-mode = "sequential"`, 1)
+	configs, _, err = Literal("test.psy", []byte(streaming))
+	if err != nil {
+		test.Fatalf("test-literal-produce-from: %s", err)
+	}
+
+	assert.Equal(test, REMOTE_SEQUENTIAL, configs["test"].RemoteMode)
+	assert.Equal(test, time.Duration(0), configs["test"].RemoteTimeout)
+
+	timed := strings.Replace(literal, "transform = []", `transform = []
+		produce-from-timeout = "1m"`, 1)
+	configs, _, err = Literal("test.psy", []byte(timed))
+	if err != nil {
+		test.Fatalf("test-literal-produce-from: %s", err)
+	}
+
+	assert.Equal(test, time.Minute, configs["test"].RemoteTimeout)
+
+	for _, broken := range []string{
+		strings.Replace(timed, `"1m"`, `"soon"`, 1),
+		strings.Replace(timed, `"1m"`, `"-1m"`, 1),
+		strings.Replace(streaming, `"sequential"`, `"whenever"`, 1),
+		strings.Replace(timed, "produce-from = produce.test.control", "produce = [produce.test.control]", 1),
+	} {
+		if _, _, err := Literal("test.psy", []byte(broken)); err == nil {
+			test.Fatalf("test-literal-produce-from: expected an error for %s", broken)
+		}
+	}
+}
+
+func TestLiteral_remoteParts(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	produce "test" "control" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		transform = []
+		transform-from = produce.test.control
+		consume-from = produce.test.control
+		consume-from-timeout = "1m"
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-remote-parts: %s", err)
+	}
+
+	assert.Equal(test, "control", configs["test"].RemoteTransformer.Name)
+	assert.Equal(test, 10*time.Second, configs["test"].RemoteTransformTimeout)
+	assert.Equal(test, "control", configs["test"].RemoteConsumer.Name)
+	assert.Equal(test, time.Minute, configs["test"].RemoteConsumeTimeout)
+
+	for _, broken := range []string{
+		strings.Replace(literal, "consume-from = produce.test.control", "", 1),
+		strings.Replace(literal, "transform-from = produce.test.control", "transform-from = produce.test.missing", 1),
+		strings.Replace(literal, "transform-from = produce.test.control", "transform-from = transform.test.control", 1),
+		strings.Replace(literal, "transform-from = produce.test.control", `transform-from-timeout = "1m"`, 1),
+		strings.Replace(literal, `"1m"`, `"-1m"`, 1),
+	} {
+		if _, _, err := Literal("test.psy", []byte(broken)); err == nil {
+			test.Fatalf("test-literal-remote-parts: expected an error for %s", broken)
+		}
+	}
+}
+
+func TestLiteral_checkpoint(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		checkpoint {}
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-checkpoint: %s", err)
+	}
+
+	assert.Equal(test, &Checkpoint{Interval: 5 * time.Second, Dir: ".psyduck/state/test"}, configs["test"].Checkpoint)
+
+	if _, _, err := Literal("test.psy", []byte(strings.Replace(literal, "checkpoint {}", `checkpoint { interval = "0s" }`, 1))); err == nil {
+		test.Fatal("test-literal-checkpoint: expected an error for a zero interval")
+	}
+}
+
+func TestLiteral_envelope(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "test" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		headers = { env = "prod" }
+		envelope = true
+	}
+	`
+
+	configs, _, err := Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatalf("test-literal-envelope: %s", err)
+	}
+
+	assert.Equal(test, map[string]string{"env": "prod"}, configs["test"].Headers)
+	assert.True(test, configs["test"].Envelope)
+}
+
+func TestLiteral_misplacedPartBlocks(test *testing.T) {
+	for block, literal := range map[string]string{
+		"buffer": `
+		produce "test" "p" {
+			buffer {
+				size = 10
+			}
+		}`,
+		"retry": `
+		consume "test" "c" {
+			retry {
+				attempts = 3
+			}
+		}`,
+		"rate-limit": `
+		transform "test" "t" {
+			rate-limit {
+				per-second = 10
+			}
+		}`,
+	} {
+		_, _, err := Literal("test.psy", []byte(literal))
+		if err == nil {
+			test.Fatalf("test-literal-misplaced-part-blocks: expected an error for %s", block)
+		}
+
+		assert.Contains(test, err.Error(), "test.psy:3,4", block)
+		assert.Contains(test, err.Error(), "a "+block+" block only applies to", block)
+	}
+}
+
+func TestLiteral_diagnostics(test *testing.T) {
+	literal := `
+	produce "test" "p" {}
+	consume "test" "c" {}
+	pipeline "a" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		workers = 0
+	}
+	pipeline "b" {
+		produce = [produce.test.p]
+		consume = [consume.test.c]
+		transform = []
+		max-duration = "soon"
+		checkpoint {
+			interval = "0s"
+		}
+	}
+	`
+
+	_, _, err := Literal("test.psy", []byte(literal))
+	var diags hcl.Diagnostics
+	if !errors.As(err, &diags) {
+		test.Fatalf("test-literal-diagnostics: expected diagnostics, got %v", err)
+	}
+
+	if !assert.Len(test, diags, 3) {
+		return
+	}
+
+	for index, line := range []int{8, 14, 16} {
+		assert.NotNil(test, diags[index].Subject)
+		assert.Equal(test, "test.psy", diags[index].Subject.Filename)
+		assert.Equal(test, line, diags[index].Subject.Start.Line, "%s", diags[index])
+	}
+}
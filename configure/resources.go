@@ -1,11 +1,14 @@
 package configure
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -15,6 +18,8 @@ const (
 	NAMESPACE_TRANSFORM = "transform"
 	NAMESPACE_VALUE     = "value"
 	NAMESPACE_ENV       = "env"
+	NAMESPACE_MSG       = "msg"
+	NAMESPACE_META      = "meta"
 )
 
 func name(namespace string, resource *pipelinePart) string {
@@ -98,6 +103,80 @@ func loadResorceLookup(filename string, literal []byte, evalCtx *hcl.EvalContext
 			lookup[name(NAMESPACE_TRANSFORM, each)] = each
 		}
 
+		refs := make([]string, 0, len(lookup))
+		for ref := range lookup {
+			refs = append(refs, ref)
+		}
+
+		sort.Strings(refs)
+		diags := make(hcl.Diagnostics, 0)
+		for _, ref := range refs {
+			diags = diags.Extend(lookupPartBlocks(strings.SplitN(ref, ".", 2)[0], lookup[ref]))
+		}
+
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
 		return lookup, nil
 	}
 }
+
+/*
+Where the first block of blockType in body is defined, or where body is if it
+can't be found
+*/
+func blockRange(body hcl.Body, blockType string) *hcl.Range {
+	if syntax, ok := body.(*hclsyntax.Body); ok {
+		for _, block := range syntax.Blocks {
+			if block.Type == blockType {
+				return block.DefRange().Ptr()
+			}
+		}
+	}
+
+	return body.MissingItemRange().Ptr()
+}
+
+/*
+Resolve the retry, buffer and rate-limit blocks of part, which is in namespace.
+Retries only apply to transformers, and buffers and rate limits to consumers, so
+those blocks are rejected anywhere else rather than being ignored
+*/
+func lookupPartBlocks(namespace string, part *pipelinePart) hcl.Diagnostics {
+	applies := []struct {
+		present   bool
+		blockType string
+		namespace string
+	}{
+		{part.RetryBlock != nil, "retry", NAMESPACE_TRANSFORM},
+		{part.BufferBlock != nil, "buffer", NAMESPACE_CONSUME},
+		{part.RateLimitBlock != nil, "rate-limit", NAMESPACE_CONSUME},
+	}
+
+	diags := make(hcl.Diagnostics, 0)
+	for _, each := range applies {
+		if each.present && namespace != each.namespace {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unsupported block type",
+				Detail:   fmt.Sprintf("a %s block only applies to %s blocks, not %s", each.blockType, each.namespace, name(namespace, part)),
+				Subject:  blockRange(part.Options, each.blockType),
+			})
+		}
+	}
+
+	if diags.HasErrors() {
+		return diags
+	}
+
+	var more hcl.Diagnostics
+	part.Retry, more = lookupRetry(part.RetryBlock, blockBody(part.Options, "retry", 0))
+	diags = diags.Extend(more)
+
+	part.Buffer, more = lookupBuffer(part.BufferBlock, blockBody(part.Options, "buffer", 0))
+	diags = diags.Extend(more)
+
+	part.RateLimit, more = lookupRateLimit(part.RateLimitBlock, blockBody(part.Options, "rate-limit", 0))
+	return diags.Extend(more)
+}
@@ -0,0 +1,74 @@
+package configure
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+type sourceFile struct {
+	name    string
+	start   int
+	content []byte
+}
+
+/*
+Where each file read from a directory is in the literal it was read into, so
+that a range in the literal can be told in terms of the file it's from
+*/
+type SourceMap struct {
+	files []*sourceFile
+}
+
+/*
+The position offset bytes into file, counting lines and columns from 1
+*/
+func (file *sourceFile) pos(offset int) hcl.Pos {
+	offset = max(0, min(offset, len(file.content)))
+	before := file.content[:offset]
+	return hcl.Pos{
+		Line:   bytes.Count(before, []byte("\n")) + 1,
+		Column: offset - bytes.LastIndexByte(before, '\n'),
+		Byte:   offset,
+	}
+}
+
+/*
+Put rng, a range in the literal, in terms of the file it starts in. Ranges
+outside of the literal are given back as they are
+*/
+func (sources *SourceMap) Locate(rng hcl.Range) hcl.Range {
+	for index := len(sources.files) - 1; index >= 0; index-- {
+		file := sources.files[index]
+		if rng.Start.Byte < file.start {
+			continue
+		}
+
+		if rng.Start.Byte > file.start+len(file.content) {
+			return rng
+		}
+
+		return hcl.Range{
+			Filename: file.name,
+			Start:    file.pos(rng.Start.Byte - file.start),
+			End:      file.pos(rng.End.Byte - file.start),
+		}
+	}
+
+	return rng
+}
+
+/*
+Put the subject and context of every diagnostic in terms of the file it's from
+*/
+func (sources *SourceMap) LocateDiagnostics(diags hcl.Diagnostics) {
+	for _, diag := range diags {
+		if diag.Subject != nil {
+			diag.Subject = sources.Locate(*diag.Subject).Ptr()
+		}
+
+		if diag.Context != nil {
+			diag.Context = sources.Locate(*diag.Context).Ptr()
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package configure
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceMap_Locate(test *testing.T) {
+	directory := test.TempDir()
+	files := map[string]string{
+		"a.psy": "value {\n  cat = \"huge\"\n}\n",
+		"b.psy": "value {\n  dog = \"small\"\n}\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(path.Join(directory, name), []byte(content), 0o644); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	literal, sources, err := ReadDirectorySources(directory)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// "small", 2 lines into b.psy
+	start := len(files["a.psy"]) + len("value {\n  dog = ")
+	located := sources.Locate(hcl.Range{
+		Filename: "literal",
+		Start:    hcl.Pos{Line: 5, Column: 9, Byte: start},
+		End:      hcl.Pos{Line: 5, Column: 16, Byte: start + 7},
+	})
+
+	assert.Equal(test, `"small"`, string(literal[start:start+7]))
+	assert.Equal(test, path.Join(directory, "b.psy"), located.Filename)
+	assert.Equal(test, hcl.Pos{Line: 2, Column: 9, Byte: len("value {\n  dog = ")}, located.Start)
+	assert.Equal(test, 16, located.End.Column)
+
+	located = sources.Locate(hcl.Range{Filename: "literal", Start: hcl.Pos{Line: 1, Column: 1}, End: hcl.Pos{Line: 1, Column: 6, Byte: 5}})
+	assert.Equal(test, path.Join(directory, "a.psy"), located.Filename)
+	assert.Equal(test, hcl.Pos{Line: 1, Column: 6, Byte: 5}, located.End)
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+)
+
+/*
+data as it is if it's valid json, otherwise data as a json string
+*/
+func jsonOrString(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return data
+	}
+
+	encoded, _ := json.Marshal(string(data)) // marshalling a string can't fail
+	return encoded
+}
+
+/*
+Encode a batch as a json array. Messages that are valid json are embedded as
+they are, anything else is embedded as a string
+*/
+func encodeJSONBatch(batch [][]byte) []byte {
+	buf := bytes.NewBufferString("[")
+	for i, data := range batch {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		buf.Write(jsonOrString(data))
+	}
+
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+/*
+Encode a batch as newline delimited messages, each followed by a newline
+*/
+func encodeLinesBatch(batch [][]byte) []byte {
+	buf := new(bytes.Buffer)
+	for _, data := range batch {
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+/*
+Wrap a consumer so that it receives batches of messages encoded as one, rather
+than each message alone. Whatever is pending is flushed when recv is closed.
+
+Along with the batching consumer is its handoff, given that of consumer as next.
+A message is let go of once consumer has let go of the batch it went out in
+*/
+func batchConsumer(batch *configure.Batch, consumer sdk.Consumer, next handoff) (sdk.Consumer, handoff) {
+	encode := encodeJSONBatch
+	if batch.Format == configure.BATCH_FORMAT_LINES {
+		encode = encodeLinesBatch
+	}
+
+	handed := new(ledger)
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		batches := make(chan []byte)
+		go consumer(batches, errs, done)
+
+		pending, waiting, sent := make([][]byte, 0, batch.Size), make([]*entry, 0, batch.Size), uint64(0)
+		var linger <-chan time.Time
+
+		flush := func() {
+			linger = nil
+			if len(pending) != 0 {
+				batches <- encode(pending)
+				sent++
+				for _, each := range waiting {
+					handed.settle(each, mark{next, sent})
+				}
+
+				pending, waiting = pending[:0], waiting[:0]
+			}
+		}
+
+		for {
+			select {
+			case data, ok := <-recv:
+				if !ok {
+					flush()
+					close(batches)
+					return
+				}
+
+				pending, waiting = append(pending, data), append(waiting, handed.add())
+				if len(pending) == 1 && batch.Linger > 0 {
+					linger = time.After(batch.Linger)
+				}
+
+				if batch.Size > 0 && len(pending) >= batch.Size {
+					flush()
+				}
+			case <-linger:
+				flush()
+			}
+		}
+	}, handed.count
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+)
+
+func Test_encodeBatch(test *testing.T) {
+	batch := [][]byte{[]byte(`{"cat":"huge"}`), []byte(`12`), []byte(`not json`)}
+
+	if have, want := encodeJSONBatch(batch), []byte(`[{"cat":"huge"},12,"not json"]`); !sdk.SameBytes(have, want) {
+		test.Fatalf("json batch mismatch: %s != %s", have, want)
+	}
+
+	if have, want := encodeLinesBatch(batch), []byte("{\"cat\":\"huge\"}\n12\nnot json\n"); !sdk.SameBytes(have, want) {
+		test.Fatalf("lines batch mismatch: %s != %s", have, want)
+	}
+}
+
+func collectBatches(batch *configure.Batch, send func(chan<- []byte)) [][]byte {
+	received, done := make([][]byte, 0), make(chan struct{})
+	consumer, _ := batchConsumer(batch, func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		for data := range recv {
+			received = append(received, data)
+		}
+
+		close(done)
+	}, nil)
+
+	recv := make(chan []byte)
+	go consumer(recv, make(chan error), done)
+	send(recv)
+	close(recv)
+	<-done
+	return received
+}
+
+func Test_batchConsumer_size(test *testing.T) {
+	batches := collectBatches(&configure.Batch{Size: 3, Format: configure.BATCH_FORMAT_LINES}, func(recv chan<- []byte) {
+		for i := 0; i < 7; i++ {
+			recv <- []byte{'0' + byte(i)}
+		}
+	})
+
+	want := []string{"0\n1\n2\n", "3\n4\n5\n", "6\n"}
+	if len(batches) != len(want) {
+		test.Fatalf("got %d batches, wanted %d", len(batches), len(want))
+	}
+
+	for i := range want {
+		if string(batches[i]) != want[i] {
+			test.Fatalf("batch %d: %q != %q", i, batches[i], want[i])
+		}
+	}
+}
+
+func Test_batchConsumer_linger(test *testing.T) {
+	batches := collectBatches(&configure.Batch{Size: 100, Linger: 10 * time.Millisecond, Format: configure.BATCH_FORMAT_JSON}, func(recv chan<- []byte) {
+		recv <- []byte(`1`)
+		recv <- []byte(`2`)
+		time.Sleep(100 * time.Millisecond)
+		recv <- []byte(`3`)
+	})
+
+	want := []string{`[1,2]`, `[3]`}
+	if len(batches) != len(want) {
+		test.Fatalf("got %d batches, wanted %d", len(batches), len(want))
+	}
+
+	for i := range want {
+		if string(batches[i]) != want[i] {
+			test.Fatalf("batch %d: %s != %s", i, batches[i], want[i])
+		}
+	}
+}
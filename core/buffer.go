@@ -0,0 +1,177 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Messages written past the end of a buffer, read back in the order they were
+written. The file is created on the first write
+*/
+type spillFile struct {
+	dir           string
+	file          *os.File
+	read, written int64
+	count         int
+}
+
+func (spill *spillFile) write(data []byte) error {
+	if spill.file == nil {
+		file, err := os.CreateTemp(spill.dir, "psyduck-spill-*")
+		if err != nil {
+			return err
+		}
+
+		spill.file = file
+	}
+
+	record := append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+	if _, err := spill.file.WriteAt(record, spill.written); err != nil {
+		return err
+	}
+
+	spill.written += int64(len(record))
+	spill.count++
+	return nil
+}
+
+func (spill *spillFile) next() ([]byte, error) {
+	header := make([]byte, binary.MaxVarintLen64)
+	read, err := spill.file.ReadAt(header, spill.read)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	size, headerSize := binary.Uvarint(header[:read])
+	if headerSize <= 0 {
+		return nil, fmt.Errorf("corrupt spill record at %d", spill.read)
+	}
+
+	data := make([]byte, size)
+	if _, err := spill.file.ReadAt(data, spill.read+int64(headerSize)); err != nil {
+		return nil, err
+	}
+
+	spill.read += int64(headerSize) + int64(size)
+	spill.count--
+	if spill.count == 0 {
+		spill.read, spill.written = 0, 0 // start over rather than growing forever
+	}
+
+	return data, nil
+}
+
+func (spill *spillFile) remove() {
+	if spill.file != nil {
+		spill.file.Close()
+		os.Remove(spill.file.Name())
+	}
+}
+
+/*
+Wrap a consumer so that it has its own buffer of messages waiting for it, letting
+it fall behind without holding up whatever is sending to it. Once the buffer is
+full, buffer.Overflow decides whether to wait for room, drop the message that
+just came in, drop the oldest waiting message, or spill to a file on disk.
+Dropped messages are counted in dropped.
+
+A spill file that can't be written to or read from is logged, and the buffer
+falls back to waiting for room.
+
+Along with the buffered consumer is its handoff, given that of consumer as next.
+A message is let go of once consumer has let go of it, or once it's dropped
+*/
+func bufferConsumer(stage string, buffer *configure.Buffer, consumer sdk.Consumer, next handoff, dropped *atomic.Int64, logger *logrus.Logger) (sdk.Consumer, handoff) {
+	handed := new(ledger)
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		forward := make(chan []byte)
+		go consumer(forward, errs, done)
+
+		overflow := buffer.Overflow
+		spill := &spillFile{dir: buffer.SpillDir}
+		defer spill.remove()
+
+		// waiting has an entry for each message queued or spilled, in the same order
+		queue, waiting, forwarded := make([][]byte, 0, buffer.Size), make([]*entry, 0, buffer.Size), uint64(0)
+		enqueue := func(data []byte) {
+			sent := handed.add()
+			full := len(queue) >= buffer.Size
+			switch {
+			case overflow == configure.OVERFLOW_SPILL && (full || spill.count != 0):
+				if err := spill.write(data); err != nil {
+					logger.WithField("stage", stage).Errorf("failed spilling to disk, blocking instead: %s", err)
+					overflow = configure.OVERFLOW_BLOCK
+					queue = append(queue, data)
+				}
+
+				waiting = append(waiting, sent)
+			case full && overflow == configure.OVERFLOW_DROP_NEWEST:
+				dropped.Add(1)
+				handed.settle(sent)
+			case full && overflow == configure.OVERFLOW_DROP_OLDEST:
+				dropped.Add(1)
+				handed.settle(waiting[0])
+				queue, waiting = append(queue[1:], data), append(waiting[1:], sent)
+			default:
+				queue, waiting = append(queue, data), append(waiting, sent)
+			}
+		}
+
+		refill := func() {
+			for spill.count != 0 && len(queue) < buffer.Size {
+				data, err := spill.next()
+				if err != nil {
+					logger.WithField("stage", stage).Errorf("failed reading spilled messages, dropping %d: %s", spill.count, err)
+					dropped.Add(int64(spill.count))
+					for _, lost := range waiting[len(waiting)-spill.count:] {
+						handed.settle(lost)
+					}
+
+					waiting = waiting[:len(waiting)-spill.count]
+					spill.count, overflow = 0, configure.OVERFLOW_BLOCK
+					return
+				}
+
+				queue = append(queue, data)
+			}
+		}
+
+		for input := recv; input != nil || len(queue) != 0; {
+			var send chan<- []byte
+			var head []byte
+			if len(queue) != 0 {
+				send, head = forward, queue[0]
+			}
+
+			receive := input
+			if overflow == configure.OVERFLOW_BLOCK && len(queue) >= buffer.Size {
+				receive = nil
+			}
+
+			select {
+			case data, ok := <-receive:
+				if !ok {
+					input = nil
+					continue
+				}
+
+				enqueue(data)
+			case send <- head:
+				forwarded++
+				handed.settle(waiting[0], mark{next, forwarded})
+				queue, waiting = queue[1:], waiting[1:]
+				refill()
+			}
+		}
+
+		close(forward)
+	}, handed.count
+}
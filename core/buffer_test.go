@@ -0,0 +1,153 @@
+package core
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Send count messages to a buffered consumer that doesn't read anything until all
+of them are sent, returning what it got and how many were dropped
+*/
+func overflowBuffer(test *testing.T, buffer *configure.Buffer, count int) ([]string, int64) {
+	received, release, done, dropped := []string{}, make(chan struct{}), make(chan struct{}), new(atomic.Int64)
+	consumer, _ := bufferConsumer("consume.test.slow", buffer, func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		<-release
+		for data := range recv {
+			received = append(received, string(data))
+		}
+
+		close(done)
+	}, nil, dropped, pipelineLogger())
+
+	recv := make(chan []byte)
+	go consumer(recv, make(chan error), done)
+	for i := 0; i < count; i++ {
+		select {
+		case recv <- []byte{'0' + byte(i)}:
+		case <-time.After(time.Second):
+			test.Fatalf("send %d blocked", i)
+		}
+	}
+
+	close(release)
+	close(recv)
+	<-done
+	return received, dropped.Load()
+}
+
+func Test_bufferConsumer_drop(test *testing.T) {
+	received, dropped := overflowBuffer(test, &configure.Buffer{Size: 2, Overflow: configure.OVERFLOW_DROP_NEWEST}, 5)
+	assert.Equal(test, []string{"0", "1"}, received)
+	assert.Equal(test, int64(3), dropped)
+
+	received, dropped = overflowBuffer(test, &configure.Buffer{Size: 2, Overflow: configure.OVERFLOW_DROP_OLDEST}, 5)
+	assert.Equal(test, []string{"3", "4"}, received)
+	assert.Equal(test, int64(3), dropped)
+}
+
+func Test_bufferConsumer_spill(test *testing.T) {
+	dir := test.TempDir()
+	received, dropped := overflowBuffer(test, &configure.Buffer{Size: 2, Overflow: configure.OVERFLOW_SPILL, SpillDir: dir}, 9)
+	assert.Equal(test, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8"}, received)
+	assert.Zero(test, dropped)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Empty(test, entries, "spill file should be removed")
+}
+
+func Test_bufferConsumer_block(test *testing.T) {
+	release, done := make(chan struct{}), make(chan struct{})
+	consumer, _ := bufferConsumer("consume.test.slow", &configure.Buffer{Size: 2, Overflow: configure.OVERFLOW_BLOCK}, func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		<-release
+		for range recv {
+		}
+
+		close(done)
+	}, nil, new(atomic.Int64), pipelineLogger())
+
+	recv := make(chan []byte)
+	go consumer(recv, make(chan error), done)
+	recv <- []byte{0}
+	recv <- []byte{1}
+	select {
+	case recv <- []byte{2}:
+		test.Fatal("send to a full buffer didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	recv <- []byte{2}
+	close(recv)
+	<-done
+}
+
+func Test_bufferConsumer_handoff(test *testing.T) {
+	release, done, handed := make(chan struct{}), make(chan struct{}), new(atomic.Uint64)
+	consumer, handoff := bufferConsumer("consume.test.slow", &configure.Buffer{Size: 2, Overflow: configure.OVERFLOW_DROP_NEWEST}, func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		<-release
+		for range recv {
+			handed.Add(1)
+		}
+
+		close(done)
+	}, handed.Load, new(atomic.Int64), pipelineLogger())
+
+	recv := make(chan []byte)
+	go consumer(recv, make(chan error), done)
+	for i := 0; i < 5; i++ {
+		recv <- []byte{byte(i)}
+	}
+
+	assert.Zero(test, handoff(), "dropped messages aren't let go of before those still buffered ahead of them")
+
+	close(release)
+	close(recv)
+	<-done
+	assert.Equal(test, uint64(5), handoff())
+}
+
+func Test_RunPipeline_dropped(test *testing.T) {
+	release, consumed, metrics := make(chan struct{}), 0, NewMetrics("test")
+	consumer, _ := bufferConsumer("consume.test.slow", &configure.Buffer{Size: 4, Overflow: configure.OVERFLOW_DROP_NEWEST}, func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		<-release
+		for range recv {
+			consumed++
+		}
+
+		close(done)
+	}, nil, &metrics.Stage("consume.test.slow").Dropped, pipelineLogger())
+
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			for i := 0; i < 20; i++ {
+				send <- []byte{byte(i)}
+			}
+
+			close(release)
+			close(send)
+			close(errs)
+		},
+		Consumer:    consumer,
+		Transformer: func(data []byte) ([]byte, error) { return data, nil },
+		Metrics:     metrics,
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// messages still on their way to the buffer when it's released may make it in
+	assert.NotZero(test, result.Dropped["consume.test.slow"])
+	assert.Equal(test, 20, consumed+result.Dropped["consume.test.slow"])
+}
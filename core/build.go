@@ -4,21 +4,46 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gastrodon/psyduck/checkpoint"
 	"github.com/gastrodon/psyduck/configure"
+	"github.com/gastrodon/psyduck/flatmap"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/psyduck-etl/sdk"
 	"github.com/sirupsen/logrus"
 )
 
 type Pipeline struct {
-	Producer    sdk.Producer
-	Consumer    sdk.Consumer
-	Transformer sdk.Transformer
-	logger      *logrus.Logger
-	StopAfter   int
-	ExitOnError bool
+	Producer        sdk.Producer
+	sources         []*source
+	Consumer        sdk.Consumer
+	Transformer     sdk.Transformer
+	FlatTransformer FlatTransformer
+	flushers        []*flushStage
+	DeadLetter      sdk.Consumer
+	Routes          []*Route
+	evalCtx         *hcl.EvalContext
+	logger          *logrus.Logger
+	Metrics         *Metrics
+	StopAfter       int
+	MaxDuration     time.Duration
+	MaxErrors       int
+	DrainTimeout    time.Duration
+	ExitOnError     bool
+	Workers         int
+	Ordered         bool
+	Batch           *configure.Batch
+	Checkpoint      *configure.Checkpoint
+	Headers         map[string]string
+	Envelope        bool
+	RateLimit       *configure.RateLimit
+
+	// the halt of the run in progress, for stages that wait to find out it's stopped
+	running *atomic.Pointer[halt]
+	// the handoff of Consumer, if it's tracked
+	handed handoff
 }
 
 func pipelineLogger() *logrus.Logger {
@@ -45,6 +70,21 @@ func pipelineLogger() *logrus.Logger {
 	return l
 }
 
+/*
+Tags every entry logged with the pipeline it's from, so that the logs of
+pipelines run together can be told apart
+*/
+type pipelineHook string
+
+func (hook pipelineHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook pipelineHook) Fire(entry *logrus.Entry) error {
+	entry.Data["pipeline"] = string(hook)
+	return nil
+}
+
 func mchan[T any](c int) []chan T {
 	g := make([]chan T, c)
 	for i := range g {
@@ -99,7 +139,7 @@ func joinProducers(producers []sdk.Producer, logger *logrus.Logger) sdk.Producer
 	tErrs := join(gErrs, logger.WithField("joined", "errs"))
 	return func(dataOut chan<- []byte, errs chan<- error) {
 		for i := 0; i < len(producers); i++ {
-			go producers[i](gData[i], gErrs[i])
+			go recoverProducer(producers[i])(gData[i], gErrs[i])
 		}
 
 	out:
@@ -129,67 +169,224 @@ func joinProducers(producers []sdk.Producer, logger *logrus.Logger) sdk.Producer
 Join a collection of consumers into a single that passes data to consumers in order
 */
 func joinConsumers(consumers []sdk.Consumer, logger *logrus.Logger) sdk.Consumer {
+	joined, _ := joinHandedConsumers(consumers, nil, logger)
+	return joined
+}
+
+/*
+Join a collection of consumers like joinConsumers, along with the handoff of the
+joined consumer given the handoff of each consumer, if any
+*/
+func joinHandedConsumers(consumers []sdk.Consumer, handed []handoff, logger *logrus.Logger) (sdk.Consumer, handoff) {
 	if len(consumers) == 1 {
-		return consumers[0]
+		if handed == nil {
+			return consumers[0], nil
+		}
+
+		return consumers[0], handed[0]
 	}
 
-	gErrs := mchan[error](len(consumers))
-	gDone := mchan[struct{}](len(consumers))
-	split := mchan[[]byte](len(consumers))
+	every := make([]int, len(consumers))
+	for i := range every {
+		every[i] = i
+	}
 
-	tErrs := join(gErrs, logger.WithField("joined", "errs"))
-	tDone := join(gDone, logger.WithField("joined", "done"))
+	fan, fanned := fanConsumers(consumers, handed, func(message) ([]int, error) { return every, nil }, bareMessage, logger)
 	return func(dataRecv <-chan []byte, errs chan<- error, done chan<- struct{}) {
-		for i := range split {
-			go consumers[i](split[i], gErrs[i], gDone[i])
-		}
-
-		handle := make(chan error)
-
+		messages := make(chan message)
 		go func() {
-			for msg := range dataRecv {
-				for i := range split {
-					split[i] <- msg
-					logger.Tracef("fwd to split[%d]\n", i)
-				}
+			defer close(messages)
+			for data := range dataRecv {
+				messages <- message{data: data}
 			}
-
-			close(handle)
 		}()
 
-		go func() {
-			for err := range tErrs {
-				if err != nil {
-					handle <- err
+		fan(messages, errs, done)
+	}, fanned
+}
+
+/*
+Join a collection of consumers into a single sink that passes each message to
+the consumers that pick chooses, in order, as encoded by encode. A message pick
+fails on still goes to whatever it chose, and the error is sent along with it as
+the payload.
+
+Every consumer's errors and done are watched together, so that errors sent
+before a consumer is done are passed on before the joined consumer is.
+
+Along with the sink is its handoff, given the handoff of each consumer, if any.
+A message is let go of once every consumer it was passed to has let go of it
+*/
+func fanConsumers(consumers []sdk.Consumer, handed []handoff, pick func(message) ([]int, error), encode func(message) []byte, logger *logrus.Logger) (sink, handoff) {
+	fanned := new(ledger)
+	return func(dataRecv <-chan message, errs chan<- error, done chan<- struct{}) {
+		split := mchan[[]byte](len(consumers))
+		wg := new(sync.WaitGroup)
+		for i := range consumers {
+			gErrs, gDone := make(chan error), make(chan struct{})
+			go consumers[i](split[i], gErrs, gDone)
+
+			wg.Add(1)
+			go func(ishadow int) {
+				defer wg.Done()
+				for {
+					select {
+					case err, ok := <-gErrs:
+						if !ok {
+							gErrs = nil
+							continue
+						}
+
+						if err != nil {
+							errs <- err
+						}
+					case <-gDone:
+						logger.Tracef("split[%d] done", ishadow)
+						if gErrs != nil {
+							go func() {
+								for err := range gErrs {
+									if err != nil {
+										errs <- err
+									}
+								}
+							}()
+						}
+
+						return
+					}
+				}
+			}(i)
+		}
+
+		sent := make([]uint64, len(consumers))
+		for msg := range dataRecv {
+			each := fanned.add()
+			picked, err := pick(msg)
+			marks := make([]mark, len(picked))
+			if len(picked) != 0 {
+				encoded := encode(msg)
+				for index, i := range picked {
+					split[i] <- encoded
+					logger.Tracef("fwd to split[%d]", i)
+					sent[i]++
+					marks[index] = mark{count: sent[i]}
+					if handed != nil {
+						marks[index].handed = handed[i]
+					}
 				}
 			}
-		}()
 
-		for err := range handle {
+			fanned.settle(each, marks...)
+
 			if err != nil {
-				errs <- err
+				errs <- &StageError{Stage: "route", Payload: msg.data, Err: err}
 			}
 		}
 
 		for i := range split {
-			logger.Tracef("closing split[%d]\n", i)
+			logger.Tracef("closing split[%d]", i)
 			close(split[i])
 		}
 
-		closed, cLock := 0, new(sync.Mutex)
-		for range tDone {
-			cLock.Lock()
-			closed++
-			if closed == len(consumers) {
-				break
+		wg.Wait()
+		logger.Trace("closing provided done")
+		close(done)
+	}, fanned.count
+}
+
+/*
+A transformer that may turn one message into any number of them, including none
+*/
+type FlatTransformer func([]byte) ([][]byte, error)
+
+/*
+A transformer as a FlatTransformer, where a nil result is no messages
+*/
+func flatten(transformer sdk.Transformer) FlatTransformer {
+	return func(data []byte) ([][]byte, error) {
+		transformed, err := transformer(data)
+		if err != nil || transformed == nil {
+			return nil, err
+		}
+
+		return [][]byte{transformed}, nil
+	}
+}
+
+/*
+Join a collection of flat transformers into a single that applies them in order,
+each to every message that the one before it gave
+*/
+func stackFlatTransform(transformers []FlatTransformer) FlatTransformer {
+	return func(data []byte) ([][]byte, error) {
+		batch := [][]byte{data}
+		for _, transformer := range transformers {
+			next := make([][]byte, 0, len(batch))
+			for _, each := range batch {
+				transformed, err := transformer(each)
+				if err != nil {
+					return nil, err
+				}
+
+				next = append(next, transformed...)
 			}
 
-			cLock.Unlock()
+			if len(next) == 0 {
+				return nil, nil
+			}
+
+			batch = next
 		}
 
-		logger.Trace("closing provided done")
-		close(done)
+		return batch, nil
+	}
+}
+
+/*
+A transformer that holds messages back, along with the transformers after it in
+the chain, which whatever it flushes has yet to go through
+*/
+type flushStage struct {
+	stage string
+	stats *StageMetrics
+	flush func(time.Time, bool) ([][]byte, error)
+	since func() time.Time // nil unless the transformer is a flatmap.Holder
+	index int
+	rest  FlatTransformer
+}
+
+/*
+Flush the transformer, passing what it gives through the rest of the chain. Each
+flushed message that the rest of the chain fails on is given to failed along with
+its error, and the rest are still passed through. If flushing fails, failed is
+given the error with no message
+*/
+func (f *flushStage) run(now time.Time, final bool, failed func([]byte, error)) [][]byte {
+	var flushed [][]byte
+	var err error
+	if panicked := protect(func() { flushed, err = f.flush(now, final) }); panicked != nil {
+		err = panicked
+	}
+
+	if err != nil {
+		f.stats.Errored.Add(1)
+		failed(nil, &StageError{Stage: f.stage, Err: err})
+		return nil
 	}
+
+	f.stats.Transformed.Add(int64(len(flushed)))
+	out := make([][]byte, 0, len(flushed))
+	for _, data := range flushed {
+		transformed, err := f.rest(data)
+		if err != nil {
+			failed(data, err)
+			continue
+		}
+
+		out = append(out, transformed...)
+	}
+
+	return out
 }
 
 /*
@@ -216,7 +413,91 @@ func stackTransform(transformers []sdk.Transformer) sdk.Transformer {
 	}
 }
 
-func collectProducer(descriptor *configure.Pipeline, context *hcl.EvalContext, library Library, logger *logrus.Logger) (sdk.Producer, error) {
+/*
+Build a producer from a config of produce blocks sent by a meta-producer
+*/
+func remoteConfig(config []byte, context *hcl.EvalContext, library Library, logger *logrus.Logger) (sdk.Producer, error) {
+	parts, err := configure.Partial("remote-producer", config, context)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts.Producers) == 0 {
+		return nil, fmt.Errorf("1 or more producer is required")
+	}
+
+	producers := make([]sdk.Producer, len(parts.Producers))
+	for index, produceDescriptor := range parts.Producers {
+		producer, err := library.Producer(produceDescriptor.Kind, context, produceDescriptor.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		producers[index] = producer
+	}
+
+	return joinProducers(producers, logger), nil
+}
+
+/*
+Add the transform and consume blocks sent by the meta-producers of transform-from
+and consume-from to a copy of descriptor, after those it has already
+*/
+func collectRemoteParts(descriptor *configure.Pipeline, context *hcl.EvalContext, library Library, logger *logrus.Logger) (*configure.Pipeline, error) {
+	fetch := func(kind string, options hcl.Body, timeout time.Duration) ([]byte, error) {
+		meta, err := library.Producer(kind, context, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed providing meta-producer: %s", err)
+		}
+
+		return awaitRemote(meta, timeout)
+	}
+
+	resolved := *descriptor
+	if remote := descriptor.RemoteTransformer; remote != nil {
+		logger.Trace("getting remote transformers")
+		config, err := fetch(remote.Kind, remote.Options, descriptor.RemoteTransformTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting transform-from: %s", err)
+		}
+
+		parts, err := configure.Partial("remote-transformer", config, context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure remote transformers: %s", err)
+		}
+
+		if len(parts.Transformers) == 0 {
+			return nil, fmt.Errorf("transform-from sent no transform blocks")
+		}
+
+		local := descriptor.Transformers
+		resolved.Transformers = append(local[:len(local):len(local)], parts.Transformers...)
+	}
+
+	if remote := descriptor.RemoteConsumer; remote != nil {
+		logger.Trace("getting remote consumers")
+		config, err := fetch(remote.Kind, remote.Options, descriptor.RemoteConsumeTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting consume-from: %s", err)
+		}
+
+		parts, err := configure.Partial("remote-consumer", config, context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure remote consumers: %s", err)
+		}
+
+		if len(parts.Consumers) == 0 {
+			return nil, fmt.Errorf("consume-from sent no consume blocks")
+		}
+
+		local := descriptor.Consumers
+		resolved.Consumers = append(local[:len(local):len(local)], parts.Consumers...)
+	}
+
+	return &resolved, nil
+}
+
+func collectSources(descriptor *configure.Pipeline, context *hcl.EvalContext, library Library, metrics *Metrics, store *checkpoint.Store, logger *logrus.Logger) ([]*source, error) {
 	if descriptor.RemoteProducer != nil {
 		logger.Trace("getting remote producer")
 		p, err := library.Producer(descriptor.RemoteProducer.Kind, context, descriptor.RemoteProducer.Options)
@@ -224,53 +505,106 @@ func collectProducer(descriptor *configure.Pipeline, context *hcl.EvalContext, l
 			return nil, fmt.Errorf("failed providing remote producer: %s", err)
 		}
 
-		// this is already scuffed
-		t := time.NewTimer(10 * time.Second)
-		defer t.Stop()
-		send, errs := make(chan []byte), make(chan error)
-		go p(send, errs)
-		select {
-		case <-t.C:
-			return nil, fmt.Errorf("timeout getting anything from the meta-producer") // stupid name? hardcoded timeout? I will fix it later TODO
-		case err := <-errs:
-			return nil, fmt.Errorf("error getting from meta-producer: %s", err)
-		case msg := <-send:
-			parts, err := configure.Partial("remote-producer", msg, context)
-			if err != nil {
-				return nil, fmt.Errorf("failed to configure remote: %s", err)
+		if descriptor.RemoteMode != configure.REMOTE_ONCE {
+			stage := stageName(configure.NAMESPACE_PRODUCE, descriptor.RemoteProducer.Kind, descriptor.RemoteProducer.Name)
+			build := func(config []byte) (sdk.Producer, error) {
+				return remoteConfig(config, context, library, logger)
 			}
 
-			return collectProducer(&configure.Pipeline{
-				Name:           descriptor.Name,
-				RemoteProducer: nil,
-				Producers:      parts.Producers,
-				Consumers:      descriptor.Consumers,
-				Transformers:   descriptor.Transformers,
-				StopAfter:      descriptor.StopAfter,
-			}, context, library, logger)
+			return []*source{{
+				stage:    stage,
+				producer: streamProducer(p, descriptor.RemoteMode == configure.REMOTE_SEQUENTIAL, descriptor.RemoteTimeout, build, logger),
+				stats:    metrics.Stage(stage),
+			}}, nil
+		}
+
+		msg, err := awaitRemote(p, descriptor.RemoteTimeout)
+		if err != nil {
+			return nil, err
 		}
+
+		parts, err := configure.Partial("remote-producer", msg, context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure remote: %s", err)
+		}
+
+		return collectSources(&configure.Pipeline{
+			Name:           descriptor.Name,
+			RemoteProducer: nil,
+			Producers:      parts.Producers,
+			Consumers:      descriptor.Consumers,
+			Transformers:   descriptor.Transformers,
+			StopAfter:      descriptor.StopAfter,
+		}, context, library, metrics, store, logger)
 	}
 
 	logger.Trace("config literal producer")
-	switch len(descriptor.Producers) {
-	case 0:
+	if len(descriptor.Producers) == 0 {
 		return nil, fmt.Errorf("1 or more producer is required")
-	case 1:
-		logger.Trace("only one producer")
-		return library.Producer(descriptor.Producers[0].Kind, context, descriptor.Producers[0].Options)
-	default:
-		producers := make([]sdk.Producer, len(descriptor.Producers))
-		for index, produceDescriptor := range descriptor.Producers {
+	}
+
+	sources := make([]*source, len(descriptor.Producers))
+	for index, produceDescriptor := range descriptor.Producers {
+		stage := stageName(configure.NAMESPACE_PRODUCE, produceDescriptor.Kind, produceDescriptor.Name)
+		src := &source{stage: stage, stats: metrics.Stage(stage)}
+		if store == nil {
 			producer, err := library.Producer(produceDescriptor.Kind, context, produceDescriptor.Options)
 			if err != nil {
 				return nil, err
 			}
 
-			producers[index] = producer
+			src.producer = producer
+		} else {
+			saved, err := store.Load(stage)
+			if err != nil {
+				return nil, fmt.Errorf("failed loading checkpoint of %s: %s", stage, err)
+			}
+
+			position := newPosition(saved)
+			producer, resumable, err := library.ResumeProducer(produceDescriptor.Kind, context, produceDescriptor.Options, saved, position.mark)
+			if err != nil {
+				return nil, err
+			}
+
+			if resumable {
+				src.position = position
+				if saved != nil {
+					logger.Infof("resuming %s from its checkpoint", stage)
+				}
+			}
+
+			src.producer = producer
 		}
 
-		return joinProducers(producers, logger), nil
+		sources[index] = src
+	}
+
+	return sources, nil
+}
+
+func collectConsumer(kind, name string, options hcl.Body, buffer *configure.Buffer, rateLimit *configure.RateLimit, context *hcl.EvalContext, library Library, metrics *Metrics, releases *[]func(), logger *logrus.Logger) (sdk.Consumer, handoff, error) {
+	consumer, release, err := library.Consumer(kind, context, options)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	if release != nil {
+		*releases = append(*releases, release)
+	}
+
+	stage := stageName(configure.NAMESPACE_CONSUME, kind, name)
+	stats, handed := metrics.Stage(stage), new(atomic.Uint64)
+	consumer = namedConsumer(stage, stats, handed, consumer)
+	if rateLimit != nil {
+		consumer = rateLimitConsumer(rateLimit, consumer, &stats.Throttled)
+	}
+
+	if buffer == nil {
+		return consumer, handed.Load, nil
+	}
+
+	consumer, buffered := bufferConsumer(stage, buffer, consumer, handed.Load, &stats.Dropped, logger)
+	return consumer, buffered, nil
 }
 
 /*
@@ -283,39 +617,143 @@ Produces a runnable pipeline.
 Each mover in the pipeline ( every producer / consumer / transformer ) is joined
 and the resulting pipeline is returned.
 */
-func BuildPipeline(descriptor *configure.Pipeline, evalCtx *hcl.EvalContext, library Library) (*Pipeline, error) {
+func BuildPipeline(descriptor *configure.Pipeline, evalCtx *hcl.EvalContext, library Library) (_ *Pipeline, err error) {
 	logger := pipelineLogger()
-	producer, err := collectProducer(descriptor, evalCtx, library, logger)
+	logger.AddHook(pipelineHook(descriptor.Name))
+	metrics := NewMetrics(descriptor.Name)
+	var store *checkpoint.Store
+	if descriptor.Checkpoint != nil {
+		store = checkpoint.NewStore(descriptor.Checkpoint.Dir)
+	}
+
+	// consumers provided that hold onto something until they're run, which they
+	// won't be if the pipeline fails to build
+	releases := make([]func(), 0)
+	defer func() {
+		if err != nil {
+			for _, release := range releases {
+				release()
+			}
+		}
+	}()
+
+	descriptor, err = collectRemoteParts(descriptor, evalCtx, library, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	consumers := make([]sdk.Consumer, len(descriptor.Consumers))
-	for index, consumeDescriptor := range descriptor.Consumers {
-		consumer, err := library.Consumer(consumeDescriptor.Kind, evalCtx, consumeDescriptor.Options)
-		if err != nil {
-			return nil, err
+	sources, err := collectSources(descriptor, evalCtx, library, metrics, store, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferOf := func(buffer *configure.Buffer) *configure.Buffer {
+		if buffer == nil {
+			return descriptor.Buffer
+		}
+
+		return buffer
+	}
+
+	var consumer sdk.Consumer
+	var handed handoff
+	if len(descriptor.Consumers) != 0 {
+		consumers, handoffs := make([]sdk.Consumer, len(descriptor.Consumers)), make([]handoff, len(descriptor.Consumers))
+		for index, consumeDescriptor := range descriptor.Consumers {
+			consumers[index], handoffs[index], err = collectConsumer(consumeDescriptor.Kind, consumeDescriptor.Name, consumeDescriptor.Options, bufferOf(consumeDescriptor.Buffer), consumeDescriptor.RateLimit, evalCtx, library, metrics, &releases, logger)
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		consumers[index] = consumer
+		consumer, handed = joinHandedConsumers(consumers, handoffs, logger)
 	}
 
-	transformers := make([]sdk.Transformer, len(descriptor.Transformers))
+	routes := make([]*Route, len(descriptor.Routes))
+	for index, routeDescriptor := range descriptor.Routes {
+		consumers, handoffs := make([]sdk.Consumer, len(routeDescriptor.Consumers)), make([]handoff, len(routeDescriptor.Consumers))
+		for index, consumeDescriptor := range routeDescriptor.Consumers {
+			consumers[index], handoffs[index], err = collectConsumer(consumeDescriptor.Kind, consumeDescriptor.Name, consumeDescriptor.Options, bufferOf(consumeDescriptor.Buffer), consumeDescriptor.RateLimit, evalCtx, library, metrics, &releases, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed providing route consumer: %s", err)
+			}
+		}
+
+		joined, joinedHanded := joinHandedConsumers(consumers, handoffs, logger)
+		routes[index] = &Route{When: routeDescriptor.When, Consumer: joined, handed: joinedHanded}
+	}
+
+	transformers := make([]FlatTransformer, len(descriptor.Transformers))
+	flushers := make([]*flushStage, 0)
+	state, running := checkpoint.NewStore(descriptor.StateDir), new(atomic.Pointer[halt])
 	for index, transformDescriptor := range descriptor.Transformers {
-		transformer, err := library.Transformer(transformDescriptor.Kind, evalCtx, transformDescriptor.Options)
+		stage := stageName(configure.NAMESPACE_TRANSFORM, transformDescriptor.Kind, transformDescriptor.Name)
+		transformer, flusher, err := library.FlatTransformer(transformDescriptor.Kind, evalCtx, transformDescriptor.Options, state, stage)
 		if err != nil {
 			return nil, err
 		}
 
-		transformers[index] = transformer
+		retry := transformDescriptor.Retry
+		if retry == nil {
+			retry = descriptor.Retry
+		}
+
+		stats := metrics.Stage(stage)
+		transformers[index] = namedTransformer(stage, meteredTransformer(stats, retryTransformer(stage, retry, recoverTransformer(transformer), &stats.Retries, running, logger)))
+		if flusher != nil {
+			flushing := &flushStage{stage: stage, stats: stats, flush: flusher.Flush, index: index}
+			if holder, ok := flusher.(flatmap.Holder); ok {
+				flushing.since = holder.HeldSince
+			}
+
+			flushers = append(flushers, flushing)
+		}
+	}
+
+	for _, flusher := range flushers {
+		flusher.rest = stackFlatTransform(transformers[flusher.index+1:])
+	}
+
+	var deadLetter sdk.Consumer
+	if descriptor.DeadLetter != nil {
+		var release func()
+		deadLetter, release, err = library.Consumer(descriptor.DeadLetter.Kind, evalCtx, descriptor.DeadLetter.Options)
+		if err != nil {
+			return nil, fmt.Errorf("failed providing dead-letter consumer: %s", err)
+		}
+
+		if release != nil {
+			releases = append(releases, release)
+		}
+
+		stage := stageName(configure.NAMESPACE_CONSUME, descriptor.DeadLetter.Kind, descriptor.DeadLetter.Name)
+		deadLetter = namedConsumer(stage, metrics.Stage(stage), nil, deadLetter)
 	}
 
 	return &Pipeline{
-		Producer:    producer,
-		Consumer:    joinConsumers(consumers, logger),
-		Transformer: stackTransform(transformers),
-		logger:      logger,
-		StopAfter:   descriptor.StopAfter,
-		ExitOnError: descriptor.ExitOnError,
+		sources:         sources,
+		Consumer:        consumer,
+		FlatTransformer: stackFlatTransform(transformers),
+		flushers:        flushers,
+		DeadLetter:      deadLetter,
+		Routes:          routes,
+		evalCtx:         evalCtx,
+		logger:          logger,
+		Metrics:         metrics,
+		StopAfter:       descriptor.StopAfter,
+		MaxDuration:     descriptor.MaxDuration,
+		MaxErrors:       descriptor.MaxErrors,
+		DrainTimeout:    descriptor.DrainTimeout,
+		ExitOnError:     descriptor.ExitOnError,
+		Workers:         descriptor.Workers,
+		Ordered:         descriptor.Ordered,
+		Batch:           descriptor.Batch,
+		Checkpoint:      descriptor.Checkpoint,
+		Headers:         descriptor.Headers,
+		Envelope:        descriptor.Envelope,
+		RateLimit:       descriptor.RateLimit,
+
+		running: running,
+		handed:  handed,
 	}, nil
 }
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RunPipeline_channel(test *testing.T) {
+	received := []string{}
+	collector := collectPlugin(&received)
+
+	literal := `
+	produce "increment" "left" {
+		stop-after = 20
+	}
+	produce "increment" "right" {
+		stop-after = 30
+	}
+	consume "channel" "middle" {
+		name = "test-chain"
+	}
+	produce "channel" "middle" {
+		name = "test-chain"
+	}
+	consume "collect" "end" {}
+	pipeline "load-left" {
+		produce = [produce.increment.left]
+		consume = [consume.channel.middle]
+		transform = []
+	}
+	pipeline "load-right" {
+		produce = [produce.increment.right]
+		consume = [consume.channel.middle]
+		transform = []
+	}
+	pipeline "move" {
+		produce = [produce.channel.middle]
+		consume = [consume.collect.end]
+		transform = []
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	library := NewLibrary([]*sdk.Plugin{collector})
+	pipelines := make([]*Pipeline, 0, len(descriptors))
+	for _, name := range []string{"move", "load-left", "load-right"} {
+		pipeline, err := BuildPipeline(descriptors[name], evalCtx, library)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		pipelines = append(pipelines, pipeline)
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, pipeline := range pipelines {
+		wg.Add(1)
+		go func(pipeline *Pipeline) {
+			defer wg.Done()
+			if _, err := RunPipeline(pipeline); err != nil {
+				test.Error(err)
+			}
+		}(pipeline)
+	}
+
+	wg.Wait()
+	assert.Len(test, received, 50)
+}
+
+func Test_BuildPipeline_channelReleased(test *testing.T) {
+	received := []string{}
+	collector := collectPlugin(&received)
+
+	literal := `
+	produce "increment" "count" {
+		stop-after = 10
+	}
+	consume "channel" "middle" {
+		name = "test-released"
+	}
+	produce "channel" "middle" {
+		name = "test-released"
+	}
+	transform "sprintf" "broken" {
+		format = "%s"
+		encoding = "morse"
+	}
+	consume "collect" "end" {}
+	pipeline "load" {
+		produce = [produce.increment.count]
+		consume = [consume.channel.middle]
+		transform = []
+	}
+	pipeline "broken" {
+		produce = [produce.increment.count]
+		consume = [consume.channel.middle]
+		transform = [transform.sprintf.broken]
+	}
+	pipeline "move" {
+		produce = [produce.channel.middle]
+		consume = [consume.collect.end]
+		transform = []
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	library := NewLibrary([]*sdk.Plugin{collector})
+	move, err := BuildPipeline(descriptors["move"], evalCtx, library)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	load, err := BuildPipeline(descriptors["load"], evalCtx, library)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := BuildPipeline(descriptors["broken"], evalCtx, library); err == nil {
+		test.Fatal("expected an error building broken")
+	}
+
+	ran := make(chan error)
+	go func() {
+		_, err := RunPipeline(move)
+		ran <- err
+	}()
+
+	if _, err := RunPipeline(load); err != nil {
+		test.Fatal(err)
+	}
+
+	select {
+	case err := <-ran:
+		if err != nil {
+			test.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("the reader wasn't told the stream ended, as broken is still counted as a writer")
+	}
+
+	assert.Len(test, received, 10)
+}
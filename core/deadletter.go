@@ -0,0 +1,185 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/psyduck-etl/sdk"
+)
+
+/*
+An error from a named stage of a pipeline, carrying the message that it failed
+on when that's known
+*/
+type StageError struct {
+	Stage   string
+	Payload []byte
+	Err     error
+}
+
+func (e *StageError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+func stageName(namespace, kind, name string) string {
+	return strings.Join([]string{namespace, kind, name}, ".")
+}
+
+/*
+Wrap a transformer so that its errors name it
+*/
+func namedTransformer(stage string, transformer FlatTransformer) FlatTransformer {
+	return func(in []byte) ([][]byte, error) {
+		out, err := transformer(in)
+		if err != nil {
+			return out, &StageError{Stage: stage, Err: err}
+		}
+
+		return out, nil
+	}
+}
+
+/*
+Wrap a consumer so that its errors name it, counting what it's handed and the
+errors it supplies in stats. An sdk.Consumer doesn't say which
+message an error came from, so errors carry the message most recently handed to
+the consumer. Handing off messages, taking errors and seeing done all happen on
+one goroutine so that what counts as most recent is exact, and so that errors
+are passed on before done is closed. If handed is given, it's also counted in
+there, so that it can be the handoff of the consumer
+*/
+func namedConsumer(stage string, stats *StageMetrics, handed *atomic.Uint64, consumer sdk.Consumer) sdk.Consumer {
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		forward, forwardErrs, forwardDone := make(chan []byte), make(chan error), make(chan struct{})
+		panicked := make(chan *PanicError, 1)
+		go func() {
+			if recovered := protect(func() { consumer(forward, forwardErrs, forwardDone) }); recovered != nil {
+				panicked <- recovered
+			}
+		}()
+
+		var last, pending []byte
+		var send chan<- []byte
+		input, open := recv, true // input is nil while a message is pending
+		dead, finished := false, false
+		for open || forwardErrs != nil || forwardDone != nil {
+			select {
+			case <-forwardDone:
+				forwardDone, finished = nil, true
+				close(done)
+			case recovered := <-panicked:
+				// a consumer that's panicked is done, and whatever's left for it is dropped
+				stats.Errored.Add(1)
+				errs <- &StageError{Stage: stage, Payload: last, Err: recovered}
+				dead, forwardErrs, forwardDone, send = true, nil, nil, nil
+				if pending != nil {
+					pending, input = nil, recv
+				}
+
+				if !finished {
+					finished = true
+					close(done)
+				}
+			case data, ok := <-input:
+				if !ok {
+					input, open = nil, false
+					close(forward)
+					continue
+				}
+
+				if dead {
+					continue
+				}
+
+				pending, send, input = data, forward, nil
+			case send <- pending:
+				stats.Consumed.Add(1)
+				if handed != nil {
+					handed.Add(1)
+				}
+
+				last, pending, send, input = pending, nil, nil, recv
+			case err, ok := <-forwardErrs:
+				if !ok {
+					forwardErrs = nil
+					continue
+				}
+
+				if err != nil {
+					stats.Errored.Add(1)
+					errs <- &StageError{Stage: stage, Payload: last, Err: err}
+				}
+			}
+		}
+
+		close(errs)
+	}
+}
+
+type deadLetter struct {
+	Error   string          `json:"error"`
+	Stage   string          `json:"stage"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+/*
+Messages that failed somewhere in a pipeline, on their way to the dead-letter
+consumer. Letters sent after close are dropped
+*/
+type deadLetters struct {
+	lock   sync.Mutex
+	closed bool
+	send   chan []byte
+	count  atomic.Int64
+}
+
+func newDeadLetters() *deadLetters {
+	return &deadLetters{send: make(chan []byte)}
+}
+
+/*
+Send payload as a letter explaining err. Unless err is a StageError naming the
+stage it came from, stage is used. A payload that's valid json is embedded as it
+is, anything else is embedded as a string, and no payload at all as null
+*/
+func (letters *deadLetters) post(stage string, payload []byte, err error) bool {
+	letter := deadLetter{Error: err.Error(), Stage: stage}
+	if payload != nil {
+		letter.Payload = jsonOrString(payload)
+	}
+
+	if stageErr := new(StageError); errors.As(err, &stageErr) {
+		letter.Error, letter.Stage = stageErr.Err.Error(), stageErr.Stage
+	}
+
+	encoded, err := json.Marshal(letter)
+	if err != nil {
+		return false
+	}
+
+	letters.lock.Lock()
+	defer letters.lock.Unlock()
+	if letters.closed {
+		return false
+	}
+
+	letters.send <- encoded
+	letters.count.Add(1)
+	return true
+}
+
+func (letters *deadLetters) close() {
+	letters.lock.Lock()
+	defer letters.lock.Unlock()
+	if !letters.closed {
+		letters.closed = true
+		close(letters.send)
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectLetters(letters *[]deadLetter) sdk.Consumer {
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		for data := range recv {
+			letter := deadLetter{}
+			if err := json.Unmarshal(data, &letter); err != nil {
+				panic(err)
+			}
+
+			*letters = append(*letters, letter)
+		}
+
+		close(done)
+	}
+}
+
+func Test_RunPipeline_deadLetterTransform(test *testing.T) {
+	limit, consumed, letters := byte(20), 0, make([]deadLetter, 0)
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			for i := byte(0); i < limit; i++ {
+				send <- []byte(fmt.Sprint(i))
+			}
+
+			close(send)
+			close(errs)
+		},
+		Consumer: func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+			for range recv {
+				consumed++
+			}
+
+			close(done)
+		},
+		FlatTransformer: stackFlatTransform([]FlatTransformer{
+			namedTransformer("transform.test.odd", flatten(func(in []byte) ([]byte, error) {
+				if in[len(in)-1]%2 == 1 {
+					return nil, fmt.Errorf("odd")
+				}
+
+				return in, nil
+			})),
+		}),
+		DeadLetter: collectLetters(&letters),
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, int(limit/2), consumed)
+	assert.Equal(test, int(limit/2), result.DeadLettered)
+	assert.Equal(test, int(limit/2), len(letters))
+	for _, letter := range letters {
+		assert.Equal(test, "transform.test.odd", letter.Stage)
+		assert.Equal(test, "odd", letter.Error)
+		var payload int
+		assert.Nil(test, json.Unmarshal(letter.Payload, &payload), "payloads that are json are embedded as they are")
+		assert.Equal(test, 1, payload%2)
+	}
+}
+
+func Test_RunPipeline_deadLetterConsume(test *testing.T) {
+	letters := make([]deadLetter, 0)
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			send <- []byte("ok")
+			send <- []byte("rejected")
+			close(send)
+			close(errs)
+		},
+		Consumer: namedConsumer("consume.test.picky", new(StageMetrics), nil, func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+			for data := range recv {
+				if string(data) == "rejected" {
+					errs <- fmt.Errorf("won't take it")
+				}
+			}
+
+			close(errs)
+			close(done)
+		}),
+		Transformer: func(in []byte) ([]byte, error) { return in, nil },
+		DeadLetter:  collectLetters(&letters),
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, 1, result.Errors)
+	assert.Equal(test, []deadLetter{{Error: "won't take it", Stage: "consume.test.picky", Payload: json.RawMessage(`"rejected"`)}}, letters)
+}
+
+func Test_RunPipeline_deadLetterFlush(test *testing.T) {
+	letters, consumed := make([]deadLetter, 0), make([]string, 0)
+	flushes := 0
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			close(send)
+			close(errs)
+		},
+		Consumer:    collectMessages(&consumed),
+		Transformer: func(in []byte) ([]byte, error) { return in, nil },
+		flushers: []*flushStage{{
+			stage: "transform.test.held",
+			stats: new(StageMetrics),
+			flush: func(time.Time, bool) ([][]byte, error) {
+				if flushes++; flushes == 1 {
+					return [][]byte{[]byte(`{"cat":"huge"}`), []byte(`{"cat":"pixie"}`)}, nil
+				}
+
+				return nil, fmt.Errorf("lost the rest")
+			},
+			rest: namedTransformer("transform.test.picky", flatten(func(in []byte) ([]byte, error) {
+				if string(in) == `{"cat":"huge"}` {
+					return nil, fmt.Errorf("too big")
+				}
+
+				return in, nil
+			})),
+		}},
+		DeadLetter: collectLetters(&letters),
+	}
+
+	pipeline.flushers = append(pipeline.flushers, pipeline.flushers[0])
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, []string{`{"cat":"pixie"}`}, consumed)
+	assert.Equal(test, 2, result.Errors)
+	assert.Equal(test, []deadLetter{
+		{Error: "too big", Stage: "transform.test.picky", Payload: json.RawMessage(`{"cat":"huge"}`)},
+		{Error: "lost the rest", Stage: "transform.test.held", Payload: json.RawMessage("null")},
+	}, letters)
+}
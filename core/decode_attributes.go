@@ -44,7 +44,12 @@ func getAttributeValue(attributes hcl.Attributes, name string, evalCtx *hcl.Eval
 	}
 }
 
-func decodeAttributes(spec sdk.SpecMap, evalCtx *hcl.EvalContext, attributes hcl.Attributes, target interface{}) hcl.Diagnostics {
+/*
+Evaluate attributes against spec, giving the value of each field of spec. Fields
+that aren't set take their default, and those that are required are reported at
+subject, the block that's missing them
+*/
+func decodeValues(spec sdk.SpecMap, evalCtx *hcl.EvalContext, attributes hcl.Attributes, subject *hcl.Range) (map[string]cty.Value, hcl.Diagnostics) {
 	diags := hcl.Diagnostics{}
 
 	valuesDecode := make(map[string]cty.Value)
@@ -64,6 +69,7 @@ func decodeAttributes(spec sdk.SpecMap, evalCtx *hcl.EvalContext, attributes hcl
 					Severity:    hcl.DiagError,
 					Summary:     "value required",
 					Detail:      fmt.Sprintf("a value is required for %s", fieldSpec.Name),
+					Subject:     subject,
 					EvalContext: evalCtx,
 				})
 			} else {
@@ -75,6 +81,10 @@ func decodeAttributes(spec sdk.SpecMap, evalCtx *hcl.EvalContext, attributes hcl
 		fieldValue = toDynCollection(fieldValue)
 		if diagsValidate := validate(fieldValue, fieldSpec); diagsValidate.HasErrors() {
 			for _, each := range diagsValidate {
+				if each.Subject == nil || each.Subject.Empty() {
+					each.Subject = attributes[name].Expr.Range().Ptr()
+				}
+
 				diags = diags.Append(each)
 			}
 
@@ -84,6 +94,11 @@ func decodeAttributes(spec sdk.SpecMap, evalCtx *hcl.EvalContext, attributes hcl
 		valuesDecode[name] = fieldValue
 	}
 
+	return valuesDecode, diags
+}
+
+func decodeAttributes(spec sdk.SpecMap, evalCtx *hcl.EvalContext, attributes hcl.Attributes, subject *hcl.Range, target interface{}) hcl.Diagnostics {
+	valuesDecode, diags := decodeValues(spec, evalCtx, attributes, subject)
 	if err := gocty.FromCtyValueTagged(cty.ObjectVal(valuesDecode), target, "psy"); err != nil {
 		diags.Append(&hcl.Diagnostic{
 			Severity:    hcl.DiagError,
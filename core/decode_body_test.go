@@ -66,7 +66,7 @@ func TestDecodeConfig(test *testing.T) {
 		Override: "overridden",
 	}
 
-	diags := decodeAttributes(spec, nil, attrs, target)
+	diags := decodeAttributes(spec, nil, attrs, nil, target)
 	assert.False(test, diags.HasErrors(), "%s", diags)
 	assert.Equal(test, want, *target, "%#v", *target)
 }
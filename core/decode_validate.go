@@ -88,11 +88,12 @@ func validateMap(value cty.Value, fieldSpec *sdk.Spec) hcl.Diagnostics {
 }
 
 func validate(value cty.Value, fieldSpec *sdk.Spec) hcl.Diagnostics {
-	if value.Type().IsListType() {
+	// a collection where the spec wants something else is a mismatch of types
+	if value.Type().IsListType() && cty.Type(fieldSpec.Type).IsListType() {
 		return validateList(value, fieldSpec)
 	}
 
-	if value.Type().IsMapType() {
+	if value.Type().IsMapType() && cty.Type(fieldSpec.Type).IsMapType() {
 		return validateMap(value, fieldSpec)
 	}
 
@@ -63,6 +63,16 @@ func TestValidate(test *testing.T) {
 			Value: cty.NilVal,
 			Spec:  &sdk.Spec{Type: cty.String, Required: true},
 		},
+		{
+			Valid: false,
+			Value: cty.ListVal([]cty.Value{cty.StringVal("huge")}),
+			Spec:  &sdk.Spec{Type: cty.String},
+		},
+		{
+			Valid: false,
+			Value: cty.MapVal(map[string]cty.Value{"honda": cty.StringVal("civic")}),
+			Spec:  &sdk.Spec{Type: cty.List(cty.String)},
+		},
 	}
 
 	for _, testcase := range cases {
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RunPipeline_persistDedupe(test *testing.T) {
+	received := []string{}
+	collector := collectPlugin(&received)
+
+	literal := `
+	produce "constant" "cats" {
+		value = "huge"
+		stop-after = 3
+	}
+	transform "dedupe" "seen" {
+		persist = true
+	}
+	consume "collect" "kept" {}
+	pipeline "test" {
+		produce = [produce.constant.cats]
+		transform = [transform.dedupe.seen]
+		consume = [consume.collect.kept]
+	}
+	`
+
+	dir := test.TempDir()
+	run := func() {
+		descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		assert.Equal(test, filepath.Join(".psyduck", "state", "test"), descriptors["test"].StateDir)
+		descriptors["test"].StateDir = filepath.Join(dir, descriptors["test"].StateDir)
+		pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{collector}))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := RunPipeline(pipeline); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	run()
+	assert.Equal(test, []string{"huge"}, received)
+	assert.FileExists(test, filepath.Join(dir, ".psyduck", "state", "test", "transform.dedupe.seen"))
+
+	run()
+	assert.Equal(test, []string{"huge"}, received, "the second run remembers what the first saw")
+}
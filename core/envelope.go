@@ -0,0 +1,97 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+/*
+Like an sdk.Consumer, but receiving messages along with what's known about them
+*/
+type sink func(recv <-chan message, errs chan<- error, done chan<- struct{})
+
+/*
+A message as encoded for consumers of a pipeline with envelope set. Messages
+flushed out of transformers weren't produced, so they have no seq
+*/
+type envelope struct {
+	Source   string            `json:"source"`
+	Seq      *uint64           `json:"seq,omitempty"`
+	Produced time.Time         `json:"produced"`
+	Headers  map[string]string `json:"headers"`
+	Data     json.RawMessage   `json:"data"`
+}
+
+func (msg message) sourceName() string {
+	if msg.source == nil {
+		return ""
+	}
+
+	return msg.source.stage
+}
+
+/*
+The seq of msg, or nil if it was flushed and so has none
+*/
+func (msg message) seqOf() *uint64 {
+	if msg.flushed {
+		return nil
+	}
+
+	return &msg.seq
+}
+
+func bareMessage(msg message) []byte {
+	return msg.data
+}
+
+/*
+Encode a message as a json object, along with what's known about it. Data that's
+valid json is embedded as it is, anything else is embedded as a string
+*/
+func envelopeMessage(msg message) []byte {
+	headers := msg.headers
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	encoded, _ := json.Marshal(envelope{ // nothing here can fail to marshal
+		Source:   msg.sourceName(),
+		Seq:      msg.seqOf(),
+		Produced: msg.produced,
+		Headers:  headers,
+		Data:     jsonOrString(msg.data),
+	})
+
+	return encoded
+}
+
+/*
+What's known about a message, as the value that meta refers to in expressions.
+The seq of a flushed message is null
+*/
+func metaValue(msg message) cty.Value {
+	seq := cty.NullVal(cty.Number)
+	if !msg.flushed {
+		seq = cty.NumberUIntVal(msg.seq)
+	}
+
+	headers := cty.MapValEmpty(cty.String)
+	if len(msg.headers) != 0 {
+		values := make(map[string]cty.Value, len(msg.headers))
+		for key, value := range msg.headers {
+			values[key] = cty.StringVal(value)
+		}
+
+		headers = cty.MapVal(values)
+	}
+
+	return cty.ObjectVal(map[string]cty.Value{
+		"source":   cty.StringVal(msg.sourceName()),
+		"seq":      seq,
+		"produced": cty.StringVal(msg.produced.Format(time.RFC3339Nano)),
+		"headers":  headers,
+	})
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func countTo(count int) sdk.Producer {
+	return func(send chan<- []byte, errs chan<- error) {
+		for i := 0; i < count; i++ {
+			send <- []byte{'0' + byte(i)}
+		}
+
+		close(send)
+		close(errs)
+	}
+}
+
+func Test_RunPipeline_envelope(test *testing.T) {
+	started := time.Now()
+	received, fromB := []string{}, []string{}
+	pipeline := &Pipeline{
+		sources: []*source{
+			{stage: "produce.test.a", producer: countTo(3)},
+			{stage: "produce.test.b", producer: countTo(2)},
+		},
+		Consumer:    collectMessages(&received),
+		Transformer: func(data []byte) ([]byte, error) { return data, nil },
+		Routes: []*Route{
+			{When: mustExpression(test, `meta.source == "produce.test.b" && meta.headers.env == "test"`), Consumer: collectMessages(&fromB)},
+		},
+		Headers:  map[string]string{"env": "test"},
+		Envelope: true,
+	}
+
+	if _, err := RunPipeline(pipeline); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Len(test, received, 5)
+	assert.Len(test, fromB, 2)
+
+	sources, seqs := map[string]int{}, map[uint64]bool{}
+	for _, each := range received {
+		decoded := envelope{}
+		if err := json.Unmarshal([]byte(each), &decoded); err != nil {
+			test.Fatal(err)
+		}
+
+		sources[decoded.Source]++
+		seqs[*decoded.Seq] = true
+		assert.Equal(test, map[string]string{"env": "test"}, decoded.Headers)
+		assert.False(test, decoded.Produced.Before(started))
+	}
+
+	assert.Equal(test, map[string]int{"produce.test.a": 3, "produce.test.b": 2}, sources)
+	assert.Len(test, seqs, 5, "every message should have its own seq")
+}
+
+func Test_envelopeMessage(test *testing.T) {
+	produced := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := message{seq: 7, data: []byte(`not json`), produced: produced}
+
+	assert.JSONEq(test,
+		`{"source":"","seq":7,"produced":"2024-01-02T03:04:05Z","headers":{},"data":"not json"}`,
+		string(envelopeMessage(msg)))
+
+	msg.data = []byte(`{"level":"info"}`)
+	assert.JSONEq(test,
+		`{"source":"","seq":7,"produced":"2024-01-02T03:04:05Z","headers":{},"data":{"level":"info"}}`,
+		string(envelopeMessage(msg)))
+}
+
+func Test_envelopeMessage_flushed(test *testing.T) {
+	produced := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := message{seq: 7, data: []byte(`3`), produced: produced, flushed: true}
+
+	assert.JSONEq(test,
+		`{"source":"","produced":"2024-01-02T03:04:05Z","headers":{},"data":3}`,
+		string(envelopeMessage(msg)))
+
+	assert.True(test, metaValue(msg).GetAttr("seq").IsNull())
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_stackFlatTransform(test *testing.T) {
+	double := func(data []byte) ([][]byte, error) { return [][]byte{data, data}, nil }
+	dropOdd := flatten(func(data []byte) ([]byte, error) {
+		if data[0]%2 == 1 {
+			return nil, nil
+		}
+
+		return data, nil
+	})
+
+	out, err := stackFlatTransform([]FlatTransformer{double, dropOdd, double})([]byte{2})
+	assert.Nil(test, err)
+	assert.Equal(test, [][]byte{{2}, {2}, {2}, {2}}, out)
+
+	out, err = stackFlatTransform([]FlatTransformer{double, dropOdd, double})([]byte{1})
+	assert.Nil(test, err)
+	assert.Empty(test, out)
+
+	_, err = stackFlatTransform([]FlatTransformer{double, func([]byte) ([][]byte, error) { return nil, fmt.Errorf("no") }})([]byte{1})
+	assert.NotNil(test, err)
+}
+
+func Test_RunPipeline_explode(test *testing.T) {
+	received := []string{}
+	collector := collectPlugin(&received, &sdk.Resource{
+		Name:  "batches",
+		Kinds: sdk.PRODUCER,
+		ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+			return func(send chan<- []byte, errs chan<- error) {
+				send <- []byte(`{"cats": ["huge", "pixie"]}`)
+				send <- []byte(`{"cats": []}`)
+				send <- []byte(`{"cats": ["edward"]}`)
+				close(send)
+				close(errs)
+			}, nil
+		},
+	})
+
+	literal := `
+	produce "batches" "cats" {}
+	transform "explode" "cats" {
+		field = "cats"
+	}
+	consume "collect" "cats" {}
+	pipeline "test" {
+		produce = [produce.batches.cats]
+		transform = [transform.explode.cats]
+		consume = [consume.collect.cats]
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{collector}))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, 3, result.Produced)
+	assert.Equal(test, []string{`"huge"`, `"pixie"`, `"edward"`}, received)
+	assert.Equal(test, int64(3), pipeline.Metrics.Stage("transform.explode.cats").Transformed.Load())
+	assert.Equal(test, int64(1), pipeline.Metrics.Stage("transform.explode.cats").Filtered.Load())
+}
+
+func Test_RunPipeline_flush(test *testing.T) {
+	received := []string{}
+	collector := collectPlugin(&received)
+
+	literal := `
+	produce "increment" "five" {
+		stop-after = 5
+	}
+	transform "window" "pairs" {
+		count = 2
+	}
+	transform "zoom" "count" {
+		field = "count"
+	}
+	consume "collect" "counts" {}
+	pipeline "test" {
+		produce = [produce.increment.five]
+		transform = [transform.window.pairs, transform.zoom.count]
+		consume = [consume.collect.counts]
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{collector}))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := RunPipeline(pipeline); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, []string{"2", "2", "1"}, received)
+	assert.Equal(test, int64(3), pipeline.Metrics.Stage("transform.window.pairs").Transformed.Load())
+}
+
+func Test_RunPipeline_flushMeta(test *testing.T) {
+	received := []string{}
+	collector := collectPlugin(&received)
+
+	dir := test.TempDir()
+	literal := fmt.Sprintf(`
+	produce "increment" "five" {
+		stop-after = 5
+	}
+	transform "window" "pairs" {
+		count = 2
+	}
+	consume "collect" "counts" {}
+	pipeline "test" {
+		produce = [produce.increment.five]
+		transform = [transform.window.pairs]
+		consume = [consume.collect.counts]
+		envelope = true
+		checkpoint {
+			dir = %q
+		}
+	}
+	`, dir)
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{collector}))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := RunPipeline(pipeline); err != nil {
+		test.Fatal(err)
+	}
+
+	if !assert.Len(test, received, 3) {
+		return
+	}
+
+	flushed := envelope{}
+	if err := json.Unmarshal([]byte(received[2]), &flushed); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, "produce.increment.five", flushed.Source, "flushed at the end of the stream")
+	assert.Nil(test, flushed.Seq, "flushed messages weren't produced, so they have no seq")
+
+	saved, err := os.ReadFile(filepath.Join(dir, "produce.increment.five"))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, "5", string(saved), "the message held in the window is finished once it's flushed")
+}
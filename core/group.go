@@ -0,0 +1,54 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+A pipeline to be run alongside others, along with how its run went once it's done
+*/
+type Run struct {
+	Name     string
+	Pipeline *Pipeline
+	Result   *Result
+	Err      error
+}
+
+/*
+Run every pipeline of runs at once until they've all finished, or ctx is done.
+With failFast, the first to fail cancels the rest, which drain as they would
+if ctx were done. The error returned joins the error of every run that failed
+*/
+func RunPipelines(ctx context.Context, runs []*Run, failFast bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := new(sync.WaitGroup)
+	for _, run := range runs {
+		wg.Add(1)
+		go func(run *Run) {
+			defer wg.Done()
+			run.Result, run.Err = RunPipelineContext(ctx, run.Pipeline)
+			if run.Err != nil && failFast {
+				cancel()
+			}
+		}(run)
+	}
+
+	wg.Wait()
+	failed := make([]error, 0)
+	for _, run := range runs {
+		if run.Err != nil {
+			failed = append(failed, fmt.Errorf("%s: %s", run.Name, run.Err))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d pipelines failed: %w", len(failed), len(runs), errors.Join(failed...))
+}
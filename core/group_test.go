@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func failing(send chan<- []byte, errs chan<- error) {
+	errs <- fmt.Errorf("no cats")
+	close(send)
+	close(errs)
+}
+
+func forever(send chan<- []byte, errs chan<- error) {
+	for {
+		send <- []byte("cat")
+	}
+}
+
+func groupPipeline(producer sdk.Producer) *Pipeline {
+	consumed := make([]string, 0)
+	return &Pipeline{
+		Producer:        producer,
+		Consumer:        collectMessages(&consumed),
+		FlatTransformer: stackFlatTransform(nil),
+		ExitOnError:     true,
+	}
+}
+
+func Test_RunPipelines(test *testing.T) {
+	runs := []*Run{
+		{Name: "ok", Pipeline: groupPipeline(countTo(5))},
+		{Name: "bad", Pipeline: groupPipeline(failing)},
+	}
+
+	err := RunPipelines(context.Background(), runs, false)
+	if err == nil {
+		test.Fatal("expected an error from the failing pipeline")
+	}
+
+	assert.Contains(test, err.Error(), "1 of 2 pipelines failed")
+	assert.Contains(test, err.Error(), "bad: ")
+	assert.Nil(test, runs[0].Err)
+	assert.Equal(test, 5, runs[0].Result.Produced)
+	assert.NotNil(test, runs[1].Err)
+}
+
+func Test_RunPipelines_failFast(test *testing.T) {
+	runs := []*Run{
+		{Name: "forever", Pipeline: groupPipeline(forever)},
+		{Name: "bad", Pipeline: groupPipeline(failing)},
+	}
+
+	err := RunPipelines(context.Background(), runs, true)
+	if err == nil {
+		test.Fatal("expected an error from the failing pipeline")
+	}
+
+	assert.Contains(test, err.Error(), "1 of 2 pipelines failed")
+	assert.Nil(test, runs[0].Err)
+	assert.Equal(test, StopCancelled, runs[0].Result.Reason)
+}
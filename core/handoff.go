@@ -0,0 +1,93 @@
+package core
+
+import "sync"
+
+/*
+How many of the messages sent to a consumer, counted from the first, it has let
+go of: handed to the plugin consumer that it ends in, or dropped for good. A
+message only counts once every one sent before it does.
+
+A nil handoff is that of a consumer that isn't tracked, which is taken to let go
+of messages as soon as they're sent to it
+*/
+type handoff func() uint64
+
+/*
+Where a message went on its way through a consumer: to the consumer of handed,
+which has to let go of count messages before it's let go of this one
+*/
+type mark struct {
+	handed handoff
+	count  uint64
+}
+
+/*
+A message sent to a consumer, and where it went once that's known
+*/
+type entry struct {
+	settled bool
+	marks   []mark
+}
+
+func (e *entry) done() bool {
+	if !e.settled {
+		return false
+	}
+
+	for _, mark := range e.marks {
+		if mark.handed != nil && mark.handed() < mark.count {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Keeps track of the messages sent to a consumer until it's let go of them, so
+that count can be its handoff. Messages are forgotten as soon as they're let go
+of, so that only those in flight are kept
+*/
+type ledger struct {
+	lock    sync.Mutex
+	handed  uint64
+	pending []*entry
+}
+
+/*
+Add a message sent to the consumer, to be settled once it's known where it went
+*/
+func (l *ledger) add() *entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.advance()
+	e := new(entry)
+	l.pending = append(l.pending, e)
+	return e
+}
+
+/*
+Say where a message went. One that went nowhere, like one that was dropped, is
+let go of once every message before it is
+*/
+func (l *ledger) settle(e *entry, marks ...mark) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e.settled, e.marks = true, marks
+}
+
+func (l *ledger) advance() {
+	for len(l.pending) != 0 && l.pending[0].done() {
+		l.pending = l.pending[1:]
+		l.handed++
+	}
+}
+
+func (l *ledger) count() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.advance()
+	return l.handed
+}
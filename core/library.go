@@ -6,6 +6,10 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/psyduck-etl/sdk"
 
+	"github.com/gastrodon/psyduck/checkpoint"
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/gastrodon/psyduck/flatmap"
+	"github.com/gastrodon/psyduck/release"
 	"github.com/gastrodon/psyduck/stdlib"
 )
 
@@ -27,17 +31,23 @@ func makeBodySchema(specMap sdk.SpecMap) *hcl.BodySchema {
 	}
 }
 
-func parser(spec sdk.SpecMap, evalCtx *hcl.EvalContext, config hcl.Body) sdk.Parser {
+func parser(spec sdk.SpecMap, evalCtx *hcl.EvalContext, config hcl.Body, parsed ...func(interface{}) error) sdk.Parser {
 	return func(target interface{}) error {
 		content, _, diags := config.PartialContent(makeBodySchema(spec))
 		if diags.HasErrors() {
 			return diags
 		}
 
-		if diags := decodeAttributes(spec, evalCtx, content.Attributes, target); diags.HasErrors() {
+		if diags := decodeAttributes(spec, evalCtx, content.Attributes, config.MissingItemRange().Ptr(), target); diags.HasErrors() {
 			return diags
 		}
 
+		for _, each := range parsed {
+			if err := each(target); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	}
 }
@@ -59,17 +69,63 @@ func (l *library) Producer(name string, ctx *hcl.EvalContext, body hcl.Body) (sd
 	return found.ProvideProducer(parser(found.Spec, ctx, body))
 }
 
-func (l *library) Consumer(name string, evalCtx *hcl.EvalContext, config hcl.Body) (sdk.Consumer, error) {
+/*
+Provide a producer resuming from position, if its config is checkpoint.Resumable.
+Whether it is is returned along with the producer
+*/
+func (l *library) ResumeProducer(name string, ctx *hcl.EvalContext, body hcl.Body, position []byte, mark func([]byte)) (sdk.Producer, bool, error) {
 	found, ok := l.resources[name]
 	if !ok {
-		return nil, fmt.Errorf("can't find resource %s", name)
+		return nil, false, fmt.Errorf("can't find resource %s", name)
+	}
+
+	if found.Kinds&sdk.PRODUCER == 0 {
+		return nil, false, fmt.Errorf("resource %s doesn't provide a producer", name)
+	}
+
+	resumed := false
+	producer, err := found.ProvideProducer(parser(found.Spec, ctx, body, func(target interface{}) error {
+		resumable, ok := target.(checkpoint.Resumable)
+		if !ok {
+			return nil
+		}
+
+		resumed = true
+		return resumable.Resume(position, mark)
+	}))
+
+	return producer, resumed, err
+}
+
+/*
+Provide a consumer, along with what lets go of it if it's never run when its
+config is a release.Releaser
+*/
+func (l *library) Consumer(name string, evalCtx *hcl.EvalContext, config hcl.Body) (sdk.Consumer, func(), error) {
+	found, ok := l.resources[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("can't find resource %s", name)
 	}
 
 	if found.Kinds&sdk.CONSUMER == 0 {
-		return nil, fmt.Errorf("resource %s doesn't provide a consumer", name)
+		return nil, nil, fmt.Errorf("resource %s doesn't provide a consumer", name)
 	}
 
-	return found.ProvideConsumer(parser(found.Spec, evalCtx, config))
+	var releaser release.Releaser
+	consumer, err := found.ProvideConsumer(parser(found.Spec, evalCtx, config, func(target interface{}) error {
+		releaser, _ = target.(release.Releaser)
+		return nil
+	}))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if releaser == nil {
+		return consumer, nil, nil
+	}
+
+	return consumer, releaser.Release, nil
 }
 
 func (l *library) Transformer(name string, evalCtx *hcl.EvalContext, config hcl.Body) (sdk.Transformer, error) {
@@ -85,10 +141,107 @@ func (l *library) Transformer(name string, evalCtx *hcl.EvalContext, config hcl.
 	return found.ProvideTransformer(parser(found.Spec, evalCtx, config))
 }
 
+/*
+Provide a transformer as a FlatTransformer, using FlatMap in place of the
+provided transformer if its config is a flatmap.FlatMapper. If its config is a
+flatmap.Flusher, that's returned too. If its config is checkpoint.Stateful, it's
+restored from store as stage
+*/
+func (l *library) FlatTransformer(name string, evalCtx *hcl.EvalContext, config hcl.Body, store *checkpoint.Store, stage string) (FlatTransformer, flatmap.Flusher, error) {
+	found, ok := l.resources[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("can't find resource %s", name)
+	}
+
+	if found.Kinds&sdk.TRANSFORMER == 0 {
+		return nil, nil, fmt.Errorf("resource %s doesn't provide a transformer", name)
+	}
+
+	var mapper flatmap.FlatMapper
+	var flusher flatmap.Flusher
+	transformer, err := found.ProvideTransformer(parser(found.Spec, evalCtx, config, func(target interface{}) error {
+		mapper, _ = target.(flatmap.FlatMapper)
+		flusher, _ = target.(flatmap.Flusher)
+		if stateful, ok := target.(checkpoint.Stateful); ok {
+			return stateful.Restore(store, stage)
+		}
+
+		return nil
+	}))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mapper != nil {
+		return mapper.FlatMap, flusher, nil
+	}
+
+	return flatten(transformer), flusher, nil
+}
+
+/*
+Check config against the spec of the resource name, as it would be checked when
+providing it for namespace, without providing it. Attributes that the spec
+doesn't have are only warned about, as they're ignored when it's provided
+*/
+func (l *library) Validate(namespace, name string, evalCtx *hcl.EvalContext, config hcl.Body) hcl.Diagnostics {
+	subject := config.MissingItemRange().Ptr()
+	found, ok := l.resources[name]
+	if !ok {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "unknown resource",
+			Detail:   fmt.Sprintf("can't find resource %s in any loaded plugin", name),
+			Subject:  subject,
+		}}
+	}
+
+	kinds := map[string]struct {
+		provides bool
+		noun     string
+	}{
+		configure.NAMESPACE_PRODUCE:   {found.Kinds&sdk.PRODUCER != 0, "producer"},
+		configure.NAMESPACE_CONSUME:   {found.Kinds&sdk.CONSUMER != 0, "consumer"},
+		configure.NAMESPACE_TRANSFORM: {found.Kinds&sdk.TRANSFORMER != 0, "transformer"},
+	}
+
+	if kind := kinds[namespace]; !kind.provides {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "wrong kind of resource",
+			Detail:   fmt.Sprintf("resource %s doesn't provide a %s", name, kind.noun),
+			Subject:  subject,
+		}}
+	}
+
+	schema := makeBodySchema(found.Spec)
+	content, _, diags := config.PartialContent(schema)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	_, decodeDiags := decodeValues(found.Spec, evalCtx, content.Attributes, subject)
+	diags = diags.Extend(decodeDiags)
+	if _, strict := config.Content(schema); strict.HasErrors() {
+		for _, each := range strict {
+			if each.Summary == "Unsupported argument" {
+				each.Severity = hcl.DiagWarning
+				diags = diags.Append(each)
+			}
+		}
+	}
+
+	return diags
+}
+
 type Library interface {
 	Producer(string, *hcl.EvalContext, hcl.Body) (sdk.Producer, error)
-	Consumer(string, *hcl.EvalContext, hcl.Body) (sdk.Consumer, error)
+	ResumeProducer(string, *hcl.EvalContext, hcl.Body, []byte, func([]byte)) (sdk.Producer, bool, error)
+	Consumer(string, *hcl.EvalContext, hcl.Body) (sdk.Consumer, func(), error)
 	Transformer(string, *hcl.EvalContext, hcl.Body) (sdk.Transformer, error)
+	FlatTransformer(string, *hcl.EvalContext, hcl.Body, *checkpoint.Store, string) (FlatTransformer, flatmap.Flusher, error)
+	Validate(string, string, *hcl.EvalContext, hcl.Body) hcl.Diagnostics
 }
 
 func NewLibrary(plugins []*sdk.Plugin) Library {
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+Upper bounds, in seconds, of the buckets that transformer latency is counted in
+*/
+var latencyBuckets = []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/*
+A count of observations by upper bound, along with their sum, in the shape of a
+prometheus histogram
+*/
+type Histogram struct {
+	lock    sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{buckets: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.count++
+	h.sum += seconds
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+/*
+What one stage of a pipeline ( a single producer, transformer or consumer ) has
+done with the messages moving through it
+*/
+type StageMetrics struct {
+	Produced    atomic.Int64
+	Transformed atomic.Int64
+	Filtered    atomic.Int64
+	Errored     atomic.Int64
+	Consumed    atomic.Int64
+	Dropped     atomic.Int64
+	Retries     atomic.Int64
+	Throttled   atomic.Int64 // nanoseconds spent waiting on a rate limit
+	Latency     *Histogram
+}
+
+/*
+Metrics of every stage of a pipeline, by stage name
+*/
+type Metrics struct {
+	Pipeline string
+	lock     sync.Mutex
+	stages   map[string]*StageMetrics
+	order    []string
+}
+
+func NewMetrics(pipeline string) *Metrics {
+	return &Metrics{Pipeline: pipeline, stages: make(map[string]*StageMetrics)}
+}
+
+/*
+The metrics of stage, which are created the first time it's asked for
+*/
+func (m *Metrics) Stage(stage string) *StageMetrics {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if stats, ok := m.stages[stage]; ok {
+		return stats
+	}
+
+	stats := &StageMetrics{Latency: newHistogram()}
+	m.stages[stage] = stats
+	m.order = append(m.order, stage)
+	return stats
+}
+
+/*
+Every stage with metrics, in the order they were first asked for
+*/
+func (m *Metrics) Stages() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]string(nil), m.order...)
+}
+
+/*
+The sum of one counter across every stage
+*/
+func (m *Metrics) total(counter func(*StageMetrics) *atomic.Int64) int {
+	total := int64(0)
+	for _, stage := range m.Stages() {
+		total += counter(m.Stage(stage)).Load()
+	}
+
+	return int(total)
+}
+
+/*
+Write a table of what every stage did
+*/
+func (m *Metrics) WriteSummary(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "STAGE\tPRODUCED\tTRANSFORMED\tFILTERED\tERRORED\tCONSUMED\tDROPPED\tRETRIES\tTHROTTLED")
+	for _, stage := range m.Stages() {
+		stats := m.Stage(stage)
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", stage,
+			stats.Produced.Load(), stats.Transformed.Load(), stats.Filtered.Load(), stats.Errored.Load(),
+			stats.Consumed.Load(), stats.Dropped.Load(), stats.Retries.Load(),
+			time.Duration(stats.Throttled.Load()).Round(time.Millisecond))
+	}
+
+	return table.Flush()
+}
+
+var counters = []struct {
+	name, help string
+	counter    func(*StageMetrics) *atomic.Int64
+}{
+	{"psyduck_messages_produced_total", "Messages produced", func(s *StageMetrics) *atomic.Int64 { return &s.Produced }},
+	{"psyduck_messages_transformed_total", "Messages transformed", func(s *StageMetrics) *atomic.Int64 { return &s.Transformed }},
+	{"psyduck_messages_filtered_total", "Messages filtered out by a transformer", func(s *StageMetrics) *atomic.Int64 { return &s.Filtered }},
+	{"psyduck_messages_errored_total", "Errors supplied by a stage", func(s *StageMetrics) *atomic.Int64 { return &s.Errored }},
+	{"psyduck_messages_consumed_total", "Messages handed to a consumer", func(s *StageMetrics) *atomic.Int64 { return &s.Consumed }},
+	{"psyduck_messages_dropped_total", "Messages dropped from a full consumer buffer", func(s *StageMetrics) *atomic.Int64 { return &s.Dropped }},
+	{"psyduck_transform_retries_total", "Retries of a failing transformer", func(s *StageMetrics) *atomic.Int64 { return &s.Retries }},
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+/*
+Write metrics in the prometheus text exposition format, labelled by pipeline and
+stage. Latency is only written for stages that have observed any
+*/
+func WritePrometheus(w io.Writer, metrics ...*Metrics) error {
+	sorted := append([]*Metrics(nil), metrics...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Pipeline < sorted[j].Pipeline })
+
+	for _, family := range counters {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", family.name, family.help, family.name); err != nil {
+			return err
+		}
+
+		for _, m := range sorted {
+			for _, stage := range m.Stages() {
+				fmt.Fprintf(w, "%s{pipeline=%q,stage=%q} %d\n", family.name, m.Pipeline, stage, family.counter(m.Stage(stage)).Load())
+			}
+		}
+	}
+
+	name := "psyduck_transform_duration_seconds"
+	if _, err := fmt.Fprintf(w, "# HELP %s Time spent in a transformer, retries included\n# TYPE %s histogram\n", name, name); err != nil {
+		return err
+	}
+
+	for _, m := range sorted {
+		for _, stage := range m.Stages() {
+			latency := m.Stage(stage).Latency
+			latency.lock.Lock()
+			if latency.count != 0 {
+				labels := fmt.Sprintf("pipeline=%q,stage=%q", m.Pipeline, stage)
+				for i, bound := range latencyBuckets {
+					fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, formatFloat(bound), latency.buckets[i])
+				}
+
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, latency.count)
+				fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(latency.sum))
+				fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, latency.count)
+			}
+
+			latency.lock.Unlock()
+		}
+	}
+
+	return nil
+}
+
+/*
+Serve metrics in the prometheus text exposition format
+*/
+func MetricsHandler(metrics ...*Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, metrics...)
+	})
+}
+
+/*
+Wrap a transformer so that its outcomes and how long it takes are counted. Each
+message a transformer gives counts as transformed, and an input that gives none
+counts as filtered
+*/
+func meteredTransformer(stats *StageMetrics, transformer FlatTransformer) FlatTransformer {
+	return func(in []byte) ([][]byte, error) {
+		started := time.Now()
+		out, err := transformer(in)
+		stats.Latency.Observe(time.Since(started))
+		switch {
+		case err != nil:
+			stats.Errored.Add(1)
+		case len(out) == 0:
+			stats.Filtered.Add(1)
+		default:
+			stats.Transformed.Add(int64(len(out)))
+		}
+
+		return out, err
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_meteredTransformer(test *testing.T) {
+	metrics := NewMetrics("test")
+	stats := metrics.Stage("transform.test.odd")
+	transformer := meteredTransformer(stats, flatten(func(data []byte) ([]byte, error) {
+		switch {
+		case data[0] == 0:
+			return nil, fmt.Errorf("zero")
+		case data[0]%2 == 0:
+			return nil, nil
+		default:
+			return data, nil
+		}
+	}))
+
+	for i := byte(0); i < 10; i++ {
+		transformer([]byte{i})
+	}
+
+	assert.Equal(test, int64(5), stats.Transformed.Load())
+	assert.Equal(test, int64(4), stats.Filtered.Load())
+	assert.Equal(test, int64(1), stats.Errored.Load())
+	assert.Equal(test, uint64(10), stats.Latency.count)
+}
+
+func Test_RunPipeline_metrics(test *testing.T) {
+	metrics := NewMetrics("test")
+	consumed := make([]string, 0)
+	pipeline := &Pipeline{
+		sources: []*source{{
+			stage: "produce.test.p",
+			stats: metrics.Stage("produce.test.p"),
+			producer: func(send chan<- []byte, errs chan<- error) {
+				for i := 0; i < 8; i++ {
+					send <- []byte{'0' + byte(i)}
+				}
+
+				close(send)
+				close(errs)
+			},
+		}},
+		Consumer:        namedConsumer("consume.test.c", metrics.Stage("consume.test.c"), nil, collectMessages(&consumed)),
+		FlatTransformer: meteredTransformer(metrics.Stage("transform.test.t"), flatten(func(data []byte) ([]byte, error) { return data, nil })),
+		Metrics:         metrics,
+	}
+
+	if _, err := RunPipeline(pipeline); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, int64(8), metrics.Stage("produce.test.p").Produced.Load())
+	assert.Equal(test, int64(8), metrics.Stage("transform.test.t").Transformed.Load())
+	assert.Equal(test, int64(8), metrics.Stage("consume.test.c").Consumed.Load())
+
+	exposed := new(bytes.Buffer)
+	if err := WritePrometheus(exposed, metrics); err != nil {
+		test.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# TYPE psyduck_messages_produced_total counter",
+		`psyduck_messages_produced_total{pipeline="test",stage="produce.test.p"} 8`,
+		`psyduck_messages_consumed_total{pipeline="test",stage="consume.test.c"} 8`,
+		"# TYPE psyduck_transform_duration_seconds histogram",
+		`psyduck_transform_duration_seconds_bucket{pipeline="test",stage="transform.test.t",le="+Inf"} 8`,
+		`psyduck_transform_duration_seconds_count{pipeline="test",stage="transform.test.t"} 8`,
+	} {
+		assert.Contains(test, exposed.String(), want)
+	}
+
+	summary := new(bytes.Buffer)
+	if err := metrics.WriteSummary(summary); err != nil {
+		test.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	assert.Len(test, lines, 4)
+	assert.True(test, strings.HasPrefix(lines[1], "produce.test.p"))
+}
+
+func Test_Histogram(test *testing.T) {
+	histogram := newHistogram()
+	histogram.Observe(time.Millisecond)
+	histogram.Observe(time.Second)
+
+	assert.Equal(test, []uint64{0, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2}, histogram.buckets)
+	assert.Equal(test, uint64(2), histogram.count)
+}
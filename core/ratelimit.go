@@ -0,0 +1,81 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+)
+
+/*
+A token bucket holding up to burst tokens, refilled at rate per second. Taking a
+token from an empty bucket reserves the next one, so that concurrent takers are
+let through in turn
+*/
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit *configure.RateLimit) *tokenBucket {
+	return &tokenBucket{rate: limit.PerSecond, burst: float64(limit.Burst), tokens: float64(limit.Burst), last: time.Now()}
+}
+
+/*
+Take a token at now, giving how long to wait before it may be used
+*/
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+/*
+Wait until a token may be used, giving how long that took
+*/
+func (b *tokenBucket) wait() time.Duration {
+	wait := b.reserve(time.Now())
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return wait
+}
+
+/*
+Wrap a consumer so that messages are handed to it no faster than limit allows.
+Time spent waiting on the limit is added to throttled, in nanoseconds
+*/
+func rateLimitConsumer(limit *configure.RateLimit, consumer sdk.Consumer, throttled *atomic.Int64) sdk.Consumer {
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		bucket := newTokenBucket(limit)
+		forward := make(chan []byte)
+		go consumer(forward, errs, done)
+		for data := range recv {
+			throttled.Add(int64(bucket.wait()))
+			forward <- data
+		}
+
+		close(forward)
+	}
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_tokenBucket(test *testing.T) {
+	bucket := newTokenBucket(&configure.RateLimit{PerSecond: 10, Burst: 2})
+	now := bucket.last
+
+	assert.Zero(test, bucket.reserve(now))
+	assert.Zero(test, bucket.reserve(now))
+	assert.Equal(test, 100*time.Millisecond, bucket.reserve(now))
+	assert.Equal(test, 200*time.Millisecond, bucket.reserve(now), "each reservation waits behind the last")
+	assert.Zero(test, bucket.reserve(now.Add(time.Second)))
+	assert.Zero(test, bucket.reserve(now.Add(time.Second)))
+	assert.Equal(test, 100*time.Millisecond, bucket.reserve(now.Add(time.Second)), "burst caps what's saved up")
+}
+
+func Test_RunPipeline_rateLimit(test *testing.T) {
+	if testing.Short() {
+		test.Skip("waits on a rate limit")
+	}
+
+	consumed := make([]string, 0)
+	pipeline := &Pipeline{
+		Producer:        countTo(10),
+		Consumer:        collectMessages(&consumed),
+		FlatTransformer: stackFlatTransform(nil),
+		RateLimit:       &configure.RateLimit{PerSecond: 50, Burst: 5},
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Len(test, consumed, 10)
+	assert.GreaterOrEqual(test, result.Elapsed, 90*time.Millisecond)
+	assert.GreaterOrEqual(test, result.Throttled, 90*time.Millisecond)
+}
+
+func Test_rateLimitConsumer(test *testing.T) {
+	if testing.Short() {
+		test.Skip("waits on a rate limit")
+	}
+
+	metrics := NewMetrics("test")
+	stats := metrics.Stage("consume.test.c")
+	consumed := make([]string, 0)
+	pipeline := &Pipeline{
+		Producer:        countTo(6),
+		Consumer:        rateLimitConsumer(&configure.RateLimit{PerSecond: 20, Burst: 1}, namedConsumer("consume.test.c", stats, nil, collectMessages(&consumed)), &stats.Throttled),
+		FlatTransformer: stackFlatTransform(nil),
+		Metrics:         metrics,
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Len(test, consumed, 6)
+	assert.GreaterOrEqual(test, result.Throttled, 240*time.Millisecond)
+	assert.Equal(test, result.Throttled, time.Duration(stats.Throttled.Load()))
+}
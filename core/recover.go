@@ -0,0 +1,99 @@
+package core
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/psyduck-etl/sdk"
+)
+
+/*
+A panic recovered from a plugin, along with the stack it panicked on
+*/
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panicked: %v", e.Value)
+}
+
+/*
+Call something provided by a plugin, giving what it panicked with if it did
+*/
+func protect(call func()) (panicked *PanicError) {
+	defer func() {
+		if value := recover(); value != nil {
+			panicked = &PanicError{Value: value, Stack: debug.Stack()}
+		}
+	}()
+
+	call()
+	return nil
+}
+
+/*
+Wrap a transformer so that a panic is returned as its error
+*/
+func recoverTransformer(transformer FlatTransformer) FlatTransformer {
+	return func(in []byte) (out [][]byte, err error) {
+		if panicked := protect(func() { out, err = transformer(in) }); panicked != nil {
+			return nil, panicked
+		}
+
+		return out, err
+	}
+}
+
+/*
+Wrap a producer so that a panic is sent as its error, after which it's done
+producing. Whatever the producer sends is passed through channels of the
+wrapper's own, so that they can be closed once it's panicked
+*/
+func recoverProducer(producer sdk.Producer) sdk.Producer {
+	return func(send chan<- []byte, errs chan<- error) {
+		innerSend, innerErrs := make(chan []byte), make(chan error)
+		returned := make(chan *PanicError, 1)
+		go func() {
+			returned <- protect(func() { producer(innerSend, innerErrs) })
+		}()
+
+		for innerSend != nil || innerErrs != nil {
+			select {
+			case data, ok := <-innerSend:
+				if !ok {
+					innerSend = nil
+					close(send)
+					continue
+				}
+
+				send <- data
+			case err, ok := <-innerErrs:
+				if !ok {
+					innerErrs = nil
+					close(errs)
+					continue
+				}
+
+				errs <- err
+			case panicked := <-returned:
+				returned = nil
+				if panicked == nil {
+					continue
+				}
+
+				errs <- panicked
+				if innerSend != nil {
+					close(send)
+				}
+
+				if innerErrs != nil {
+					close(errs)
+				}
+
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RunPipeline_panicTransform(test *testing.T) {
+	letters, consumed := make([]deadLetter, 0), make([]string, 0)
+	pipeline := &Pipeline{
+		Producer: countTo(6),
+		Consumer: collectMessages(&consumed),
+		FlatTransformer: namedTransformer("transform.test.boom", recoverTransformer(flatten(func(in []byte) ([]byte, error) {
+			if in[0] == '3' {
+				panic("boom")
+			}
+
+			return in, nil
+		}))),
+		DeadLetter: collectLetters(&letters),
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, 1, result.Errors)
+	assert.Len(test, consumed, 5)
+	assert.Equal(test, []deadLetter{{Error: "panicked: boom", Stage: "transform.test.boom", Payload: []byte("3")}}, letters)
+
+	pipeline.ExitOnError = true
+	pipeline.Producer, pipeline.DeadLetter = countTo(6), nil
+	_, err = RunPipeline(pipeline)
+	if err == nil {
+		test.Fatal("expected exit-on-error to stop on the panic")
+	}
+
+	assert.Contains(test, err.Error(), "transform.test.boom: panicked: boom")
+}
+
+func Test_RunPipeline_panicProduce(test *testing.T) {
+	consumed := make([]string, 0)
+	pipeline := &Pipeline{
+		sources: []*source{{
+			stage: "produce.test.boom",
+			producer: func(send chan<- []byte, errs chan<- error) {
+				send <- []byte("cat")
+				send <- []byte("cat")
+				panic("boom")
+			},
+		}},
+		Consumer:        collectMessages(&consumed),
+		FlatTransformer: stackFlatTransform(nil),
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, 2, result.Produced)
+	assert.Equal(test, 1, result.Errors)
+	assert.Len(test, consumed, 2)
+}
+
+func Test_RunPipeline_panicConsume(test *testing.T) {
+	letters, consumed := make([]deadLetter, 0), 0
+	boom := func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		for data := range recv {
+			if data[0] == '2' {
+				panic("boom")
+			}
+
+			consumed++
+		}
+
+		close(done)
+	}
+
+	pipeline := &Pipeline{
+		Producer:        countTo(6),
+		Consumer:        namedConsumer("consume.test.boom", new(StageMetrics), nil, boom),
+		FlatTransformer: stackFlatTransform(nil),
+		DeadLetter:      collectLetters(&letters),
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, 2, consumed)
+	assert.Equal(test, 1, result.Errors)
+	assert.Equal(test, []deadLetter{{Error: "panicked: boom", Stage: "consume.test.boom", Payload: []byte("2")}}, letters)
+}
+
+func Test_joinProducers_panic(test *testing.T) {
+	producer := joinProducers([]sdk.Producer{countTo(3), func(send chan<- []byte, errs chan<- error) { panic("boom") }}, pipelineLogger())
+	send, errs := make(chan []byte), make(chan error, 1)
+	go producer(send, errs)
+
+	count := 0
+	for range send {
+		count++
+	}
+
+	assert.Equal(test, 3, count)
+	assert.Equal(test, "panicked: boom", (<-errs).Error())
+}
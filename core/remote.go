@@ -0,0 +1,150 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Wait for the first message of meta, giving up after timeout unless it's 0
+*/
+func awaitRemote(meta sdk.Producer, timeout time.Duration) ([]byte, error) {
+	var expired <-chan time.Time
+	if timeout != 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	send, errs := make(chan []byte), make(chan error)
+	go recoverProducer(meta)(send, errs)
+	for {
+		select {
+		case <-expired:
+			return nil, fmt.Errorf("got nothing from the meta-producer in %s", timeout)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			return nil, fmt.Errorf("error getting from meta-producer: %s", err)
+		case msg, ok := <-send:
+			if !ok {
+				return nil, fmt.Errorf("meta-producer finished without sending anything")
+			}
+
+			return msg, nil
+		}
+	}
+}
+
+/*
+Run producer until it's done sending and its errors are closed, passing on what
+it sends and its errors
+*/
+func forwardProducer(producer sdk.Producer, send chan<- []byte, errs chan<- error) {
+	innerSend, innerErrs := make(chan []byte), make(chan error)
+	go recoverProducer(producer)(innerSend, innerErrs)
+	for innerSend != nil || innerErrs != nil {
+		select {
+		case data, ok := <-innerSend:
+			if !ok {
+				innerSend = nil
+				continue
+			}
+
+			send <- data
+		case err, ok := <-innerErrs:
+			if !ok {
+				innerErrs = nil
+				continue
+			}
+
+			if err != nil {
+				errs <- err
+			}
+		}
+	}
+}
+
+/*
+A producer fed by a meta-producer, each message of which is a config of produce
+blocks that build turns into a producer of its own. Those producers are run
+alongside each other, or with sequential one at a time, with the next config not
+read until the one before is done. Once the meta-producer is done, or no config
+has come from it for timeout, the producer is done when those already running
+are. A timeout of 0 waits on the meta-producer forever
+*/
+func streamProducer(meta sdk.Producer, sequential bool, timeout time.Duration, build func([]byte) (sdk.Producer, error), logger *logrus.Logger) sdk.Producer {
+	return func(send chan<- []byte, errs chan<- error) {
+		configs, metaErrs := make(chan []byte), make(chan error)
+		go recoverProducer(meta)(configs, metaErrs)
+
+		running := new(sync.WaitGroup)
+		defer func() {
+			running.Wait()
+			close(send)
+			close(errs)
+		}()
+
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		for count := 0; ; {
+			var idle <-chan time.Time
+			if timeout != 0 {
+				if timer != nil {
+					timer.Stop()
+				}
+
+				timer = time.NewTimer(timeout)
+				idle = timer.C
+			}
+
+			select {
+			case <-idle:
+				logger.Infof("nothing from the meta-producer in %s, not waiting on it any more", timeout)
+				return
+			case err, ok := <-metaErrs:
+				if !ok {
+					metaErrs = nil
+				} else if err != nil {
+					errs <- err
+				}
+			case config, ok := <-configs:
+				if !ok {
+					logger.Trace("meta-producer is done")
+					return
+				}
+
+				count++
+				producer, err := build(config)
+				if err != nil {
+					errs <- fmt.Errorf("failed configuring producers from meta-producer message %d: %w", count, err)
+					continue
+				}
+
+				logger.Debugf("starting producers from meta-producer message %d", count)
+				if sequential {
+					forwardProducer(producer, send, errs)
+					continue
+				}
+
+				running.Add(1)
+				go func() {
+					defer running.Done()
+					forwardProducer(producer, send, errs)
+				}()
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendAll(messages ...string) sdk.Producer {
+	return func(send chan<- []byte, errs chan<- error) {
+		for _, message := range messages {
+			send <- []byte(message)
+		}
+
+		close(send)
+		close(errs)
+	}
+}
+
+/*
+Run producer to the end, collecting what it sends and its errors
+*/
+func drainProducer(producer sdk.Producer) ([]string, []error) {
+	send, errs := make(chan []byte), make(chan error)
+	go producer(send, errs)
+
+	sent, failed := make([]string, 0), make([]error, 0)
+	for send != nil || errs != nil {
+		select {
+		case data, ok := <-send:
+			if !ok {
+				send = nil
+				continue
+			}
+
+			sent = append(sent, string(data))
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			failed = append(failed, err)
+		}
+	}
+
+	return sent, failed
+}
+
+func Test_forwardProducer(test *testing.T) {
+	returned := make(chan struct{})
+	lateError := func(send chan<- []byte, errs chan<- error) {
+		defer close(returned)
+		send <- []byte("huge")
+		close(send)
+		errs <- fmt.Errorf("late")
+		close(errs)
+	}
+
+	sent, failed := drainProducer(func(send chan<- []byte, errs chan<- error) {
+		forwardProducer(lateError, send, errs)
+		close(send)
+		close(errs)
+	})
+
+	assert.Equal(test, []string{"huge"}, sent)
+	assert.Equal(test, []error{fmt.Errorf("late")}, failed, "errors sent after send is closed are still forwarded")
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		test.Fatal("the forwarded producer never returned")
+	}
+}
+
+func Test_streamProducer(test *testing.T) {
+	build := func(config []byte) (sdk.Producer, error) {
+		if string(config) == "bad" {
+			return nil, fmt.Errorf("bad config")
+		}
+
+		return sendAll(string(config), string(config)), nil
+	}
+
+	sent, failed := drainProducer(streamProducer(sendAll("a", "bad", "b"), true, 0, build, pipelineLogger()))
+	assert.Equal(test, []string{"a", "a", "b", "b"}, sent)
+	assert.Len(test, failed, 1)
+	assert.Contains(test, failed[0].Error(), "message 2: bad config")
+
+	sent, failed = drainProducer(streamProducer(sendAll("a", "b", "c"), false, 0, build, pipelineLogger()))
+	sort.Strings(sent)
+	assert.Equal(test, []string{"a", "a", "b", "b", "c", "c"}, sent)
+	assert.Empty(test, failed)
+}
+
+func Test_streamProducer_timeout(test *testing.T) {
+	quiet := func(send chan<- []byte, errs chan<- error) {
+		send <- []byte("2")
+		// a control queue that's gone quiet, and never says it's done
+	}
+
+	build := func(config []byte) (sdk.Producer, error) {
+		return countTo(int(config[0] - '0')), nil
+	}
+
+	started := time.Now()
+	sent, failed := drainProducer(streamProducer(quiet, false, 50*time.Millisecond, build, pipelineLogger()))
+	assert.Equal(test, []string{"0", "1"}, sent)
+	assert.Empty(test, failed)
+	assert.GreaterOrEqual(test, time.Since(started), 50*time.Millisecond)
+}
+
+func Test_streamProducer_panic(test *testing.T) {
+	build := func(config []byte) (sdk.Producer, error) {
+		if string(config) == "boom" {
+			return func(send chan<- []byte, errs chan<- error) { panic("boom") }, nil
+		}
+
+		return sendAll(string(config)), nil
+	}
+
+	sent, failed := drainProducer(streamProducer(sendAll("a", "boom", "b"), true, 0, build, pipelineLogger()))
+	assert.Equal(test, []string{"a", "b"}, sent)
+	assert.Len(test, failed, 1)
+	assert.Equal(test, "panicked: boom", failed[0].Error())
+}
+
+func Test_BuildPipeline_remoteParts(test *testing.T) {
+	received := []string{}
+	control := collectPlugin(&received, &sdk.Resource{
+		Name:  "transforms",
+		Kinds: sdk.PRODUCER,
+		ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+			return sendAll(`transform "sprintf" "loud" {
+				format = "%s!"
+				encoding = "string"
+			}`), nil
+		},
+	}, &sdk.Resource{
+		Name:  "consumers",
+		Kinds: sdk.PRODUCER,
+		ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+			return sendAll(`consume "collect" "remote" {}`), nil
+		},
+	}, &sdk.Resource{
+		Name:  "nothing",
+		Kinds: sdk.PRODUCER,
+		ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+			return sendAll(), nil
+		},
+	})
+
+	literal := `
+	produce "constant" "cat" {
+		value = "cat"
+		stop-after = 2
+	}
+	produce "transforms" "control" {}
+	produce "consumers" "control" {}
+	produce "nothing" "control" {}
+	pipeline "test" {
+		produce = [produce.constant.cat]
+		transform = []
+		transform-from = produce.transforms.control
+		consume-from = produce.consumers.control
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{control}))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := RunPipeline(pipeline); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, []string{"cat!", "cat!"}, received)
+	assert.Equal(test, int64(2), pipeline.Metrics.Stage("consume.collect.remote").Consumed.Load())
+	assert.Empty(test, descriptors["test"].Transformers, "the descriptor itself is left alone")
+
+	descriptors, evalCtx, err = configure.Literal("test.psy", []byte(strings.Replace(literal, "produce.consumers.control", "produce.nothing.control", 1)))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	_, err = BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{control}))
+	if err == nil {
+		test.Fatal("expected an error from a meta-producer that sends nothing")
+	}
+
+	assert.Contains(test, err.Error(), "consume-from")
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+How long to wait before the given retry, counting from 1
+*/
+func backoff(retry *configure.Retry, attempt int) time.Duration {
+	wait := retry.InitialBackoff
+	for i := 1; i < attempt && wait < retry.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > retry.MaxBackoff {
+		wait = retry.MaxBackoff
+	}
+
+	if retry.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * retry.Jitter * float64(wait))
+	}
+
+	return wait
+}
+
+/*
+Wrap a transformer so that failures are tried again according to retry. Every
+retry is logged and counted in retries. The error from the final try is returned
+if none succeed, or from the last one made if the run that stopped holds is
+stopped while waiting to retry
+*/
+func retryTransformer(stage string, retry *configure.Retry, transformer FlatTransformer, retries *atomic.Int64, stopped *atomic.Pointer[halt], logger *logrus.Logger) FlatTransformer {
+	if retry == nil || retry.Attempts <= 1 {
+		return transformer
+	}
+
+	return func(in []byte) ([][]byte, error) {
+		var stop <-chan struct{}
+		if running := stopped.Load(); running != nil {
+			stop = running.c
+		}
+
+		out, err := transformer(in)
+		for attempt := 1; err != nil && attempt < retry.Attempts; attempt++ {
+			wait := backoff(retry, attempt)
+			logger.WithField("stage", stage).Warnf("attempt %d/%d failed, retrying in %s: %s", attempt, retry.Attempts, wait, err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				logger.WithField("stage", stage).Warnf("stopped before attempt %d/%d", attempt+1, retry.Attempts)
+				return out, err
+			}
+
+			retries.Add(1)
+			out, err = transformer(in)
+		}
+
+		return out, err
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_backoff(test *testing.T) {
+	retry := &configure.Retry{Attempts: 10, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, wait := range want {
+		assert.Equal(test, wait*time.Millisecond, backoff(retry, i+1), "attempt %d", i+1)
+	}
+
+	retry.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		wait := backoff(retry, 1)
+		assert.True(test, wait > 5*time.Millisecond && wait <= 10*time.Millisecond, "jittered wait %s", wait)
+	}
+}
+
+func failTimes(n int) (func([]byte) ([]byte, error), *int) {
+	tries := new(int)
+	return func(in []byte) ([]byte, error) {
+		if *tries++; *tries <= n {
+			return nil, fmt.Errorf("try %d failed", *tries)
+		}
+
+		return in, nil
+	}, tries
+}
+
+func Test_retryTransformer(test *testing.T) {
+	retry := &configure.Retry{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	testcases := [...]struct {
+		failures, tries, retries int
+		fails                    bool
+	}{
+		{0, 1, 0, false},
+		{2, 3, 2, false},
+		{5, 3, 2, true},
+	}
+
+	for i, testcase := range testcases {
+		retries := new(atomic.Int64)
+		transformer, tries := failTimes(testcase.failures)
+		out, err := retryTransformer("transform.test.flaky", retry, flatten(transformer), retries, new(atomic.Pointer[halt]), pipelineLogger())([]byte("in"))
+
+		assert.Equal(test, testcase.fails, err != nil, "case %d: %s", i, err)
+		assert.Equal(test, testcase.tries, *tries, "case %d", i)
+		assert.Equal(test, int64(testcase.retries), retries.Load(), "case %d", i)
+		if !testcase.fails {
+			assert.Equal(test, [][]byte{[]byte("in")}, out, "case %d", i)
+		}
+	}
+}
+
+func Test_retryTransformer_stopped(test *testing.T) {
+	retry := &configure.Retry{Attempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Minute}
+	running, retries := new(atomic.Pointer[halt]), new(atomic.Int64)
+	running.Store(newHalt())
+	transformer, tries := failTimes(5)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		running.Load().stop(StopCancelled)
+	}()
+
+	started := time.Now()
+	_, err := retryTransformer("transform.test.flaky", retry, flatten(transformer), retries, running, pipelineLogger())([]byte("in"))
+	assert.NotNil(test, err)
+	assert.Less(test, time.Since(started), time.Second, "stopping shouldn't wait out the backoff")
+	assert.Equal(test, 1, *tries)
+	assert.Zero(test, retries.Load())
+}
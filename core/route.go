@@ -0,0 +1,122 @@
+package core
+
+import (
+	"errors"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/psyduck-etl/sdk"
+	"github.com/sirupsen/logrus"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+/*
+A consumer that only gets messages for which When is true, given msg and meta.
+A Route without When is a default, getting the messages that no other route did
+*/
+type Route struct {
+	When     hcl.Expression
+	Consumer sdk.Consumer
+
+	// the handoff of Consumer, if it's tracked
+	handed handoff
+}
+
+/*
+Decode a message into the value that msg refers to in a route condition. Json is
+decoded into its cty equivalent, anything else is a string
+*/
+func messageValue(data []byte) cty.Value {
+	if ty, err := ctyjson.ImpliedType(data); err == nil {
+		if value, err := ctyjson.Unmarshal(data, ty); err == nil {
+			return value
+		}
+	}
+
+	return cty.StringVal(string(data))
+}
+
+/*
+The variables that an expression about msg is evaluated with: msg being its
+decoded data, and meta what's known about it
+*/
+func messageVariables(msg message) map[string]cty.Value {
+	return map[string]cty.Value{
+		configure.NAMESPACE_MSG:  messageValue(msg.data),
+		configure.NAMESPACE_META: metaValue(msg),
+	}
+}
+
+/*
+Whether when holds given variables. Anything but a known true is false
+*/
+func routeMatches(when hcl.Expression, variables map[string]cty.Value, evalCtx *hcl.EvalContext) (bool, error) {
+	ctx := evalCtx.NewChild()
+	ctx.Variables = variables
+	value, diags := when.Value(ctx)
+	if diags.HasErrors() {
+		return false, diags
+	}
+
+	value, err := convert.Convert(value, cty.Bool)
+	if err != nil {
+		return false, err
+	}
+
+	return value.IsKnown() && !value.IsNull() && value.True(), nil
+}
+
+/*
+Join a consumer that gets every message with routes that each get only the
+messages they match, encoding messages for them with encode. Either may be left
+out. A route condition that can't be evaluated is an error and counts as not
+matching. Along with the sink is its handoff, given handed as that of broadcast
+*/
+func routeSink(broadcast sdk.Consumer, handed handoff, routes []*Route, evalCtx *hcl.EvalContext, encode func(message) []byte, logger *logrus.Logger) (sink, handoff) {
+	consumers, handoffs, offset := make([]sdk.Consumer, 0, len(routes)+1), make([]handoff, 0, len(routes)+1), 0
+	if broadcast != nil {
+		consumers, handoffs, offset = append(consumers, broadcast), append(handoffs, handed), 1
+	}
+
+	for _, route := range routes {
+		consumers, handoffs = append(consumers, route.Consumer), append(handoffs, route.handed)
+	}
+
+	return fanConsumers(consumers, handoffs, func(msg message) ([]int, error) {
+		picked := make([]int, 0, len(consumers))
+		if broadcast != nil {
+			picked = append(picked, 0)
+		}
+
+		var variables map[string]cty.Value
+		matched, fallback := false, []int{}
+		var errs []error
+		for i, route := range routes {
+			if route.When == nil {
+				fallback = append(fallback, i+offset)
+				continue
+			}
+
+			if variables == nil {
+				variables = messageVariables(msg)
+			}
+
+			ok, err := routeMatches(route.When, variables, evalCtx)
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+			if ok {
+				matched, picked = true, append(picked, i+offset)
+			}
+		}
+
+		if !matched {
+			picked = append(picked, fallback...)
+		}
+
+		return picked, errors.Join(errs...)
+	}, encode, logger)
+}
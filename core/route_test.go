@@ -0,0 +1,110 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectMessages(messages *[]string) sdk.Consumer {
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		for data := range recv {
+			*messages = append(*messages, string(data))
+		}
+
+		close(done)
+	}
+}
+
+/*
+A plugin named test with a collect consumer that appends what it gets to
+messages, along with any other resources a test needs
+*/
+func collectPlugin(messages *[]string, resources ...*sdk.Resource) *sdk.Plugin {
+	return &sdk.Plugin{
+		Name: "test",
+		Resources: append([]*sdk.Resource{{
+			Name:  "collect",
+			Kinds: sdk.CONSUMER,
+			ProvideConsumer: func(sdk.Parser) (sdk.Consumer, error) {
+				return collectMessages(messages), nil
+			},
+		}}, resources...),
+	}
+}
+
+func mustExpression(test *testing.T, source string) hcl.Expression {
+	expr, diags := hclsyntax.ParseExpression([]byte(source), "test.psy", hcl.InitialPos)
+	if diags.HasErrors() {
+		test.Fatal(diags)
+	}
+
+	return expr
+}
+
+func Test_RunPipeline_route(test *testing.T) {
+	sent := []string{`{"level":"error"}`, `{"level":"info"}`, `not json`, `{"level":"error","n":1}`}
+	all, alerts, rest, letters := []string{}, []string{}, []string{}, []deadLetter{}
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			for _, data := range sent {
+				send <- []byte(data)
+			}
+
+			close(send)
+			close(errs)
+		},
+		Consumer:    collectMessages(&all),
+		Transformer: func(data []byte) ([]byte, error) { return data, nil },
+		DeadLetter:  collectLetters(&letters),
+		Routes: []*Route{
+			{When: mustExpression(test, `msg.level == "error"`), Consumer: collectMessages(&alerts)},
+			{Consumer: collectMessages(&rest)},
+		},
+	}
+
+	result, err := RunPipeline(pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, sent, all)
+	assert.Equal(test, []string{`{"level":"error"}`, `{"level":"error","n":1}`}, alerts)
+	assert.Equal(test, []string{`{"level":"info"}`, `not json`}, rest)
+
+	// a string has no level, so the condition fails to evaluate for it
+	assert.Equal(test, 1, result.Errors)
+	assert.Len(test, letters, 1)
+	assert.Equal(test, "route", letters[0].Stage)
+	assert.Equal(test, json.RawMessage(`"not json"`), letters[0].Payload, "payloads that aren't json are embedded as strings")
+}
+
+func Test_RunPipeline_routeOnly(test *testing.T) {
+	big, small := []string{}, []string{}
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			for _, data := range []string{"1", "20", "3", "40"} {
+				send <- []byte(data)
+			}
+
+			close(send)
+			close(errs)
+		},
+		Transformer: func(data []byte) ([]byte, error) { return data, nil },
+		Routes: []*Route{
+			{When: mustExpression(test, `msg >= 10`), Consumer: collectMessages(&big)},
+			{When: mustExpression(test, `msg < 10`), Consumer: collectMessages(&small)},
+		},
+	}
+
+	if _, err := RunPipeline(pipeline); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, []string{"20", "40"}, big)
+	assert.Equal(test, []string{"1", "3"}, small)
+}
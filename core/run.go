@@ -1,56 +1,550 @@
 package core
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
-func RunPipeline(pipeline *Pipeline) error {
-	dataProducer, errorProducer := make(chan []byte), make(chan error)
-	dataConsumer, errorConsumer, finishConsumer := make(chan []byte), make(chan error), make(chan struct{})
-	errs := make(chan error)
+	"github.com/gastrodon/psyduck/checkpoint"
+	"github.com/psyduck-etl/sdk"
+)
 
-	go pipeline.Producer(dataProducer, errorProducer)
-	go pipeline.Consumer(dataConsumer, errorConsumer, finishConsumer)
+/*
+How often transformers that hold messages back are flushed
+*/
+const flushInterval = time.Second
 
+/*
+Why a pipeline run came to an end
+*/
+type StopReason string
+
+const (
+	StopExhausted   StopReason = "exhausted"
+	StopAfter       StopReason = "stop-after"
+	StopMaxDuration StopReason = "max-duration"
+	StopMaxErrors   StopReason = "max-errors"
+	StopError       StopReason = "exit-on-error"
+	StopCancelled   StopReason = "cancelled"
+)
+
+type Result struct {
+	Reason       StopReason
+	Produced     int
+	Errors       int
+	DeadLettered int
+	Retries      int
+	Dropped      map[string]int
+	Throttled    time.Duration
+	Elapsed      time.Duration
+}
+
+/*
+halt stops a run at most once, keeping the first reason given
+*/
+type halt struct {
+	once   sync.Once
+	reason StopReason
+	c      chan struct{}
+}
+
+func newHalt() *halt {
+	return &halt{reason: StopExhausted, c: make(chan struct{})}
+}
+
+func (h *halt) stop(reason StopReason) {
+	h.once.Do(func() {
+		h.reason = reason
+		close(h.c)
+	})
+}
+
+/*
+Run a pipeline until its producers are exhausted or one of its limits is reached
+*/
+func RunPipeline(pipeline *Pipeline) (*Result, error) {
+	return RunPipelineContext(context.Background(), pipeline)
+}
+
+/*
+Run a pipeline until its producers are exhausted, one of its limits is reached,
+or ctx is done.
+
+When stopped, producers stop being read from and whatever was already produced is
+moved through to the consumer before it's closed. If the pipeline has a drain
+timeout, the consumer has that long to finish before the run gives up on it. A
+producer can't be interrupted through sdk.Producer, so one that's still producing
+is left parked on its next send.
+*/
+func RunPipelineContext(ctx context.Context, pipeline *Pipeline) (*Result, error) {
+	logger := pipeline.logger
+	if logger == nil {
+		logger = pipelineLogger()
+	}
+
+	started := time.Now()
+	result := &Result{Reason: StopExhausted}
+	producedCount := new(atomic.Int64)
+	stopped := newHalt()
+	if pipeline.running != nil {
+		pipeline.running.Store(stopped)
+	}
+
+	returned := make(chan struct{})
+	defer close(returned)
+
+	dataConsumer, errorConsumer, finishConsumer := make(chan message), make(chan error), make(chan struct{})
+	errs, finished := make(chan error), make(chan struct{})
+
+	report := func(err error) {
+		select {
+		case errs <- err:
+		case <-returned:
+		}
+	}
+
+	batched := func(consumer sdk.Consumer, handed handoff) (sdk.Consumer, handoff) {
+		if consumer != nil && pipeline.Batch != nil {
+			return batchConsumer(pipeline.Batch, consumer, handed)
+		}
+
+		return consumer, handed
+	}
+
+	encode := bareMessage
+	if pipeline.Envelope {
+		encode = envelopeMessage
+	}
+
+	// routes are batched on their own so that conditions see single messages
+	routes := make([]*Route, len(pipeline.Routes))
+	for i, route := range pipeline.Routes {
+		consumer, handed := batched(route.Consumer, route.handed)
+		routes[i] = &Route{When: route.When, Consumer: consumer, handed: handed}
+	}
+
+	broadcast, handed := batched(pipeline.Consumer, pipeline.handed)
+	consumer, handed := routeSink(broadcast, handed, routes, pipeline.evalCtx, encode, logger)
+	confirms := &confirmations{handed: handed}
+
+	var letters *deadLetters
+	finishDeadLetter := make(chan struct{})
+	if pipeline.DeadLetter != nil {
+		letters = newDeadLetters()
+		errorDeadLetter := make(chan error)
+		go pipeline.DeadLetter(letters.send, errorDeadLetter, finishDeadLetter)
+		go func() {
+			for err := range errorDeadLetter {
+				if err != nil {
+					report(fmt.Errorf("dead-letter consumer supplied error: %s", err))
+				}
+			}
+		}()
+	} else {
+		close(finishDeadLetter)
+	}
+
+	sources := pipeline.sources
+	if pipeline.Producer != nil {
+		sources = append([]*source{{producer: pipeline.Producer}}, sources...)
+	}
+
+	var checkpoints *checkpointer
+	var saveTick <-chan time.Time
+	if pipeline.Checkpoint != nil {
+		checkpoints = newCheckpointer(checkpoint.NewStore(pipeline.Checkpoint.Dir), sources)
+		ticker := time.NewTicker(pipeline.Checkpoint.Interval)
+		defer ticker.Stop()
+		saveTick = ticker.C
+	}
+
+	// confirm what the consumer's let go of and save it, for the last time if final
+	saveCheckpoints := func(final bool) error {
+		if checkpoints == nil {
+			return nil
+		}
+
+		confirms.confirm()
+		if err := checkpoints.save(final); err != nil {
+			return fmt.Errorf("failed saving checkpoint: %s", err)
+		}
+
+		return nil
+	}
+
+	dataProducer := readSources(sources, stopped.c, report)
+	go consumer(dataConsumer, errorConsumer, finishConsumer)
+
+	handleConsumerErr := func(err error) {
+		if err == nil {
+			return
+		}
+
+		report(fmt.Errorf("consumer supplied error: %w", err))
+		if stageErr := new(StageError); letters != nil && errors.As(err, &stageErr) && stageErr.Payload != nil {
+			letters.post("consume", stageErr.Payload, err)
+		}
+	}
+
+	// errors and done are watched together so that any error sent before done is
+	// handled before the consumer counts as finished
+	consumed := make(chan struct{})
 	go func() {
-		for err := range errorProducer {
-			if err != nil {
-				errs <- fmt.Errorf("producer supplied error: %s", err)
+		defer close(consumed)
+		for errs := errorConsumer; ; {
+			select {
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+
+				handleConsumerErr(err)
+			case <-finishConsumer:
+				if errs != nil {
+					go func() {
+						for err := range errs {
+							handleConsumerErr(err)
+						}
+					}()
+				}
+
+				return
 			}
 		}
 	}()
 
+	// how many messages have been taken up to be transformed, counted just before each is
+	taken := new(atomic.Uint64)
+	produced := make(chan message)
 	go func() {
-		for err := range errorConsumer {
-			if err != nil {
-				errs <- fmt.Errorf("consumer supplied error: %s", err)
+		defer close(produced)
+		for seq := uint64(0); ; seq++ {
+			select {
+			case <-stopped.c:
+				return
+			case msg, ok := <-dataProducer:
+				if !ok {
+					return
+				}
+
+				count := producedCount.Add(1)
+				msg.seq, msg.headers = seq, pipeline.Headers
+				taken.Store(seq + 1)
+				produced <- msg
+				if pipeline.StopAfter != 0 && count >= int64(pipeline.StopAfter) {
+					stopped.stop(StopAfter)
+					return
+				}
 			}
 		}
 	}()
 
+	transformer := pipeline.FlatTransformer
+	if transformer == nil {
+		transformer = flatten(pipeline.Transformer)
+	}
+
+	transformer = recoverTransformer(transformer)
+
+	// whether what failed was dead-lettered
+	failTransform := func(payload []byte, err error) bool {
+		report(fmt.Errorf("transformer supplied error: %w", err))
+		if letters == nil {
+			return false
+		}
+
+		letters.post("transform", payload, err)
+		return true
+	}
+
+	transform := func(msg message) message {
+		transformed, err := transformer(msg.data)
+		msg.transformed = time.Now()
+		if err != nil && failTransform(msg.data, err) {
+			msg.outputs = nil
+			return msg
+		}
+
+		msg.outputs = transformed
+		return msg
+	}
+
+	var bucket *tokenBucket
+	if pipeline.RateLimit != nil {
+		bucket = newTokenBucket(pipeline.RateLimit)
+	}
+
+	// how many messages have been delivered to the consumer, which is only touched
+	// by the goroutine moving messages to it
+	delivered := uint64(0)
+	throttled := new(atomic.Int64)
+	deliver := func(msg message) {
+		if bucket != nil {
+			throttled.Add(int64(bucket.wait()))
+		}
+
+		dataConsumer <- msg
+		delivered++
+	}
+
+	// When transformers hold messages back, a message that made it through them may
+	// still be held in a window. Such messages are held here until the transformers
+	// hold nothing from before they made it through. Then, as a message taken up
+	// before that may have closed a window over them, they wait until every message
+	// taken up by then has been through, counted in arrived by seq. What's flushed
+	// comes from the source of the latest message, but has no seq. These are only
+	// touched by the goroutine moving messages to the consumer
+	type holding struct {
+		msg   message
+		after uint64 // once set, how many messages have to have been through
+	}
+
+	var held []holding
+	var latest message
+	arrived, through, releasing := make(map[uint64]bool), uint64(0), uint64(math.MaxUint64)
+	heldSince := func() (time.Time, bool) {
+		var since time.Time
+		for _, flusher := range pipeline.flushers {
+			if flusher.since == nil {
+				return time.Time{}, false
+			}
+
+			if each := flusher.since(); !each.IsZero() && (since.IsZero() || each.Before(since)) {
+				since = each
+			}
+		}
+
+		return since, true
+	}
+
+	release := func(all bool) {
+		kept := held[:0]
+		releasing = math.MaxUint64
+		for _, each := range held {
+			if all || each.after != 0 && each.after <= through {
+				confirms.wait(each.msg, delivered)
+				continue
+			}
+
+			if each.after != 0 && each.after < releasing {
+				releasing = each.after
+			}
+
+			kept = append(kept, each)
+		}
+
+		held = kept
+	}
+
+	flush := func(now time.Time, final bool) {
+		for _, flusher := range pipeline.flushers {
+			flushed := flusher.run(now, final, func(payload []byte, err error) { failTransform(payload, err) })
+			for _, data := range flushed {
+				deliver(message{data: data, source: latest.source, produced: now, headers: pipeline.Headers, flushed: true})
+			}
+		}
+
+		if final {
+			release(true)
+			return
+		}
+
+		since, known := heldSince()
+		if !known {
+			return
+		}
+
+		upTo := taken.Load()
+		for i := range held {
+			if held[i].after == 0 && (since.IsZero() || held[i].msg.transformed.Before(since)) {
+				held[i].after = upTo
+			}
+		}
+
+		release(false)
+	}
+
+	var flushTick <-chan time.Time
+	if len(pipeline.flushers) != 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+	transformed := parallelTransform(pipeline.Workers, pipeline.Ordered, transform, produced)
 	go func() {
-		for msg := range dataProducer {
-			transformed, err := pipeline.Transformer(msg)
-			if err != nil {
-				errs <- fmt.Errorf("transformer supplied error: %s", err)
+		defer close(finished)
+		for {
+			var msg message
+			var ok bool
+			select {
+			case now := <-flushTick:
+				flush(now, false)
+				continue
+			case msg, ok = <-transformed:
+			}
+
+			if !ok {
+				break
+			}
+
+			latest = msg
+			for _, data := range msg.outputs {
+				out := msg
+				out.data = data
+				deliver(out)
 			}
 
-			if transformed == nil {
+			if len(pipeline.flushers) == 0 {
+				confirms.wait(msg, delivered)
 				continue
 			}
 
-			dataConsumer <- transformed
+			if msg.source != nil && msg.source.position != nil {
+				msg.data, msg.outputs = nil, nil
+				held = append(held, holding{msg: msg})
+			}
+
+			for arrived[msg.seq] = true; arrived[through]; through++ {
+				delete(arrived, through)
+			}
+
+			if through >= releasing {
+				release(false)
+			}
 		}
 
+		flush(time.Now(), true)
 		close(dataConsumer)
-		<-finishConsumer
-		close(errs)
+		<-consumed
+		if err := saveCheckpoints(true); err != nil {
+			report(err)
+		}
+
+		if letters != nil {
+			letters.close()
+		}
+
+		<-finishDeadLetter
 	}()
 
-	for err := range errs {
-		pipeline.logger.Error(err)
+	var deadline <-chan time.Time
+	if pipeline.MaxDuration > 0 {
+		timer := time.NewTimer(pipeline.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	summarize := func() *Result {
+		result.Reason, result.Produced, result.Elapsed = stopped.reason, int(producedCount.Load()), time.Since(started)
+		if letters != nil {
+			result.DeadLettered = int(letters.count.Load())
+		}
+
+		result.Dropped, result.Throttled = make(map[string]int), time.Duration(throttled.Load())
+		if pipeline.Metrics != nil {
+			result.Retries = pipeline.Metrics.total(func(s *StageMetrics) *atomic.Int64 { return &s.Retries })
+			result.Throttled += time.Duration(pipeline.Metrics.total(func(s *StageMetrics) *atomic.Int64 { return &s.Throttled }))
+			for _, stage := range pipeline.Metrics.Stages() {
+				if dropped := pipeline.Metrics.Stage(stage).Dropped.Load(); dropped != 0 {
+					result.Dropped[stage] = int(dropped)
+				}
+			}
+		}
+
+		return result
+	}
+
+	var lastErr error
+	handle := func(err error) error {
+		result.Errors++
+		lastErr = err
+		logger.Error(err)
+		if panicked := new(PanicError); errors.As(err, &panicked) {
+			logger.Debugf("stack of the panic:\n%s", panicked.Stack)
+		}
+
 		if pipeline.ExitOnError {
+			stopped.stop(StopError)
 			return err
 		}
+
+		if pipeline.MaxErrors != 0 && result.Errors >= pipeline.MaxErrors {
+			stopped.stop(StopMaxErrors)
+		}
+
+		return nil
+	}
+
+	// a run that returns early saves what was confirmed, and no more after that
+	fail := func(err error) (*Result, error) {
+		if err := saveCheckpoints(true); err != nil {
+			logger.Error(err)
+		}
+
+		return summarize(), err
 	}
 
-	return nil
+	halted, cancelled := stopped.c, ctx.Done()
+	var drainDeadline <-chan time.Time
+	for {
+		select {
+		case err := <-errs:
+			if err := handle(err); err != nil {
+				return fail(err)
+			}
+		case <-saveTick:
+			if err := saveCheckpoints(false); err != nil {
+				if err := handle(err); err != nil {
+					return fail(err)
+				}
+			}
+		case <-deadline:
+			stopped.stop(StopMaxDuration)
+		case <-cancelled:
+			cancelled = nil
+			logger.Info("pipeline cancelled, draining")
+			stopped.stop(StopCancelled)
+		case <-halted:
+			halted = nil
+			if pipeline.DrainTimeout > 0 {
+				timer := time.NewTimer(pipeline.DrainTimeout)
+				defer timer.Stop()
+				drainDeadline = timer.C
+			}
+		case <-drainDeadline:
+			return fail(fmt.Errorf("consumer didn't finish within drain-timeout %s", pipeline.DrainTimeout))
+		case <-finished:
+			for drained := false; !drained; {
+				select {
+				case err := <-errs:
+					if err := handle(err); err != nil {
+						return fail(err)
+					}
+				default:
+					drained = true
+				}
+			}
+
+			summarize()
+			for stage, dropped := range result.Dropped {
+				logger.Warnf("%s dropped %d messages from a full buffer", stage, dropped)
+			}
+
+			if result.Reason != StopExhausted {
+				logger.Infof("pipeline stopped by %s after %d messages", result.Reason, result.Produced)
+			}
+
+			if result.Reason == StopMaxErrors {
+				return result, fmt.Errorf("reached max-errors %d, last error: %s", pipeline.MaxErrors, lastErr)
+			}
+
+			return result, nil
+		}
+	}
 }
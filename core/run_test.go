@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"testing"
@@ -84,7 +85,7 @@ func testPipeline(testcase testPipelineCase) error {
 		Transformer: transformer,
 	}
 
-	if err := RunPipeline(pipeline); err != nil {
+	if _, err := RunPipeline(pipeline); err != nil {
 		return err
 	}
 
@@ -158,7 +159,7 @@ func Test_RunPipeline_filtering(test *testing.T) {
 		},
 	}
 
-	if err := RunPipeline(testcase); err != nil {
+	if _, err := RunPipeline(testcase); err != nil {
 		test.Fatal(err)
 	}
 
@@ -201,7 +202,9 @@ func Test_RunPipeline_error(test *testing.T) {
 	consumeErr := func(n int) sdk.Consumer {
 		return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
 			for i := 0; i < n; i++ {
-				<-recv
+				if _, ok := <-recv; !ok {
+					return
+				}
 			}
 
 			errs <- fmt.Errorf(errText)
@@ -260,7 +263,7 @@ func Test_RunPipeline_error(test *testing.T) {
 		testcase.pipeline.logger = pipelineTestLogger(func() {
 			didFire = true
 		})
-		if err := RunPipeline(testcase.pipeline); err == nil {
+		if _, err := RunPipeline(testcase.pipeline); err == nil {
 			test.Fatal("no error returned")
 		} else if err.Error() != testcase.want.Error() {
 			test.Fatalf("other error: %s != %s!", err, testcase.want)
@@ -269,3 +272,122 @@ func Test_RunPipeline_error(test *testing.T) {
 		}
 	}
 }
+
+func Test_RunPipeline_limits(test *testing.T) {
+	produceForever := func(send chan<- []byte, errs chan<- error) {
+		for {
+			send <- []byte{0}
+		}
+	}
+
+	consumeCount := func(count *int) sdk.Consumer {
+		return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+			for range recv {
+				*count++
+			}
+
+			close(done)
+		}
+	}
+
+	passthrough := func(in []byte) ([]byte, error) { return in, nil }
+	failing := func(in []byte) ([]byte, error) { return nil, fmt.Errorf("always fails") }
+
+	testcases := [...]struct {
+		pipeline *Pipeline
+		reason   StopReason
+		fails    bool
+		consumed int
+	}{
+		{&Pipeline{StopAfter: 25, Transformer: passthrough}, StopAfter, false, 25},
+		{&Pipeline{MaxDuration: 50 * time.Millisecond, Transformer: passthrough}, StopMaxDuration, false, -1},
+		{&Pipeline{MaxErrors: 5, Transformer: failing}, StopMaxErrors, true, 0},
+	}
+
+	for i, testcase := range testcases {
+		consumed := 0
+		testcase.pipeline.Producer = produceForever
+		testcase.pipeline.Consumer = consumeCount(&consumed)
+
+		result, err := RunPipeline(testcase.pipeline)
+		if testcase.fails != (err != nil) {
+			test.Fatalf("case %d: unexpected error state: %v", i, err)
+		}
+
+		if result.Reason != testcase.reason {
+			test.Fatalf("case %d: stopped by %s, wanted %s", i, result.Reason, testcase.reason)
+		}
+
+		if testcase.consumed >= 0 && consumed != testcase.consumed {
+			test.Fatalf("case %d: consumed %d, wanted %d", i, consumed, testcase.consumed)
+		}
+	}
+}
+
+func Test_RunPipelineContext(test *testing.T) {
+	produceForever := func(send chan<- []byte, errs chan<- error) {
+		for {
+			send <- []byte{0}
+		}
+	}
+
+	passthrough := func(in []byte) ([]byte, error) { return in, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed, flushed := 0, false
+	pipeline := &Pipeline{
+		Producer: produceForever,
+		Consumer: func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+			for range recv {
+				if consumed++; consumed == 10 {
+					cancel()
+				}
+			}
+
+			flushed = true
+			close(done)
+		},
+		Transformer: passthrough,
+	}
+
+	result, err := RunPipelineContext(ctx, pipeline)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if result.Reason != StopCancelled {
+		test.Fatalf("stopped by %s, wanted %s", result.Reason, StopCancelled)
+	}
+
+	if !flushed {
+		test.Fatal("consumer wasn't allowed to finish")
+	}
+
+	if result.Produced != consumed {
+		test.Fatalf("produced %d but consumed %d", result.Produced, consumed)
+	}
+}
+
+func Test_RunPipelineContext_drainTimeout(test *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pipeline := &Pipeline{
+		Producer: func(send chan<- []byte, errs chan<- error) {
+			for {
+				send <- []byte{0}
+			}
+		},
+		Consumer: func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+			for range recv {
+			}
+			// never closes done
+		},
+		Transformer:  func(in []byte) ([]byte, error) { return in, nil },
+		DrainTimeout: 50 * time.Millisecond,
+	}
+
+	if _, err := RunPipelineContext(ctx, pipeline); err == nil {
+		test.Fatal("expected the drain to time out")
+	}
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+One time that a scheduled pipeline came up. A run that's skipped because the
+last is still going has no result
+*/
+type ScheduledRun struct {
+	Pipeline string    `json:"pipeline"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Skipped  bool      `json:"skipped,omitempty"`
+	Result   *Result   `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+/*
+A pipeline with a schedule, and whether it's running right now
+*/
+type scheduled struct {
+	descriptor *configure.Pipeline
+	next       func(time.Time) time.Time
+	lock       sync.Mutex
+	running    int
+	queued     bool
+}
+
+/*
+Runs pipelines on their schedules, building each run from its descriptor, and
+keeps a history of the most recent runs
+*/
+type Scheduler struct {
+	evalCtx   *hcl.EvalContext
+	library   Library
+	logger    *logrus.Logger
+	pipelines []*scheduled
+	keep      int
+	lock      sync.Mutex
+	history   []*ScheduledRun
+	running   sync.WaitGroup
+}
+
+/*
+A scheduler of every descriptor that has a schedule, keeping up to keep runs in
+its history. A negative keep keeps none, as does 0
+*/
+func NewScheduler(descriptors []*configure.Pipeline, evalCtx *hcl.EvalContext, library Library, keep int) *Scheduler {
+	keep = max(keep, 0)
+	scheduler := &Scheduler{evalCtx: evalCtx, library: library, logger: pipelineLogger(), keep: keep}
+	for _, descriptor := range descriptors {
+		if descriptor.Schedule != nil {
+			scheduler.pipelines = append(scheduler.pipelines, &scheduled{descriptor: descriptor, next: descriptor.Schedule.Next})
+		}
+	}
+
+	return scheduler
+}
+
+func (s *Scheduler) record(run *ScheduledRun) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.history = append(s.history, run)
+	if len(s.history) > s.keep {
+		s.history = s.history[len(s.history)-s.keep:]
+	}
+}
+
+/*
+The most recent runs, oldest first
+*/
+func (s *Scheduler) History() []*ScheduledRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]*ScheduledRun(nil), s.history...)
+}
+
+/*
+Serve the history of recent runs as json
+*/
+func (s *Scheduler) HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.History())
+	})
+}
+
+func (s *Scheduler) execute(ctx context.Context, entry *scheduled) {
+	name := entry.descriptor.Name
+	run := &ScheduledRun{Pipeline: name, Started: time.Now()}
+	defer func() {
+		run.Finished = time.Now()
+		s.record(run)
+	}()
+
+	pipeline, err := BuildPipeline(entry.descriptor, s.evalCtx, s.library)
+	if err != nil {
+		run.Error = fmt.Sprintf("failed building: %s", err)
+		s.logger.Errorf("scheduled run of %s %s", name, run.Error)
+		return
+	}
+
+	run.Result, err = RunPipelineContext(ctx, pipeline)
+	if err != nil {
+		run.Error = err.Error()
+		s.logger.Errorf("scheduled run of %s failed: %s", name, err)
+		return
+	}
+
+	s.logger.Infof("scheduled run of %s %s after %d messages with %d errors", name, run.Result.Reason, run.Result.Produced, run.Result.Errors)
+}
+
+/*
+Start a run of entry, unless one is already going and its overlap says not to.
+A queued run starts once the one going finishes, and any number of runs coming
+up while it's going are queued as one
+*/
+func (s *Scheduler) trigger(ctx context.Context, entry *scheduled) {
+	entry.lock.Lock()
+	if entry.running != 0 {
+		switch entry.descriptor.Overlap {
+		case configure.OVERLAP_QUEUE:
+			entry.queued = true
+			entry.lock.Unlock()
+			return
+		case configure.OVERLAP_SKIP:
+			entry.lock.Unlock()
+			now := time.Now()
+			s.logger.Warnf("skipping scheduled run of %s, the last is still running", entry.descriptor.Name)
+			s.record(&ScheduledRun{Pipeline: entry.descriptor.Name, Started: now, Finished: now, Skipped: true})
+			return
+		}
+	}
+
+	entry.running++
+	entry.lock.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		for {
+			s.execute(ctx, entry)
+
+			entry.lock.Lock()
+			if entry.queued && ctx.Err() == nil {
+				entry.queued = false
+				entry.lock.Unlock()
+				continue
+			}
+
+			entry.queued = false
+			entry.running--
+			entry.lock.Unlock()
+			return
+		}
+	}()
+}
+
+/*
+Run pipelines on their schedules until ctx is done, then wait for the runs that
+are going to drain
+*/
+func (s *Scheduler) Serve(ctx context.Context) error {
+	if len(s.pipelines) == 0 {
+		return fmt.Errorf("no pipelines have a schedule")
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, entry := range s.pipelines {
+		wg.Add(1)
+		go func(entry *scheduled) {
+			defer wg.Done()
+			for {
+				next := entry.next(time.Now())
+				if next.IsZero() {
+					s.logger.Warnf("%s won't come up on its schedule again", entry.descriptor.Name)
+					return
+				}
+
+				timer := time.NewTimer(time.Until(next))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+					s.trigger(ctx, entry)
+				}
+			}
+		}(entry)
+	}
+
+	wg.Wait()
+	s.running.Wait()
+	return nil
+}
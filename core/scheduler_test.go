@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Scheduler(test *testing.T) {
+	if testing.Short() {
+		test.Skip("waits on scheduled runs")
+	}
+
+	for _, overlap := range []string{configure.OVERLAP_SKIP, configure.OVERLAP_QUEUE, configure.OVERLAP_ALLOW} {
+		running, most := new(atomic.Int64), new(atomic.Int64)
+		slow := &sdk.Plugin{
+			Name: "test",
+			Resources: []*sdk.Resource{{
+				Name:  "slow",
+				Kinds: sdk.PRODUCER,
+				ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+					return func(send chan<- []byte, errs chan<- error) {
+						now := running.Add(1)
+						for seen := most.Load(); now > seen && !most.CompareAndSwap(seen, now); seen = most.Load() {
+						}
+
+						time.Sleep(100 * time.Millisecond)
+						send <- []byte("cat")
+						running.Add(-1)
+						close(send)
+						close(errs)
+					}, nil
+				},
+			}},
+		}
+
+		literal := strings.ReplaceAll(`
+		produce "slow" "p" {}
+		consume "trash" "c" {}
+		pipeline "scheduled" {
+			produce = [produce.slow.p]
+			consume = [consume.trash.c]
+			transform = []
+			schedule = "* * * * *"
+			overlap = "OVERLAP"
+		}
+		pipeline "unscheduled" {
+			produce = [produce.slow.p]
+			consume = [consume.trash.c]
+			transform = []
+		}
+		`, "OVERLAP", overlap)
+
+		descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		scheduler := NewScheduler([]*configure.Pipeline{descriptors["scheduled"], descriptors["unscheduled"]}, evalCtx, NewLibrary([]*sdk.Plugin{slow}), 100)
+		assert.Len(test, scheduler.pipelines, 1)
+		scheduler.pipelines[0].next = func(now time.Time) time.Time { return now.Add(30 * time.Millisecond) }
+
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		if err := scheduler.Serve(ctx); err != nil {
+			test.Fatal(err)
+		}
+
+		cancel()
+		ran, skipped := 0, 0
+		for _, run := range scheduler.History() {
+			assert.Equal(test, "scheduled", run.Pipeline)
+			if run.Skipped {
+				skipped++
+				continue
+			}
+
+			ran++
+			assert.Empty(test, run.Error)
+		}
+
+		switch overlap {
+		case configure.OVERLAP_SKIP:
+			assert.NotZero(test, skipped, overlap)
+			assert.Equal(test, int64(1), most.Load(), overlap)
+		case configure.OVERLAP_QUEUE:
+			assert.Zero(test, skipped, overlap)
+			assert.Equal(test, int64(1), most.Load(), overlap)
+			assert.GreaterOrEqual(test, ran, 2, overlap)
+		case configure.OVERLAP_ALLOW:
+			assert.Zero(test, skipped, overlap)
+			assert.Greater(test, most.Load(), int64(1), overlap)
+		}
+	}
+}
+
+func Test_Scheduler_history(test *testing.T) {
+	scheduler := NewScheduler(nil, nil, nil, 2)
+	for _, name := range []string{"a", "b", "c"} {
+		scheduler.record(&ScheduledRun{Pipeline: name})
+	}
+
+	history := scheduler.History()
+	assert.Len(test, history, 2)
+	assert.Equal(test, "b", history[0].Pipeline)
+	assert.NotNil(test, scheduler.Serve(context.Background()), "nothing to serve without a schedule")
+}
+
+func Test_Scheduler_historyNegative(test *testing.T) {
+	scheduler := NewScheduler(nil, nil, nil, -1)
+	scheduler.record(&ScheduledRun{Pipeline: "a"})
+	assert.Empty(test, scheduler.History())
+}
@@ -0,0 +1,256 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gastrodon/psyduck/checkpoint"
+	"github.com/psyduck-etl/sdk"
+)
+
+/*
+A producer of a pipeline, along with what's needed to say where its messages
+came from and how far through it the pipeline has gotten
+*/
+type source struct {
+	stage    string
+	producer sdk.Producer
+	stats    *StageMetrics
+	position *position // nil unless the producer is resumable
+}
+
+/*
+Where a resumable producer is up to. The producer marks the position after each
+message before sending it, and each message is paired with its mark when it's
+read. A position is confirmed once its message and every one before it have
+made it through the pipeline
+*/
+type position struct {
+	lock      sync.Mutex
+	marked    [][]byte
+	finished  map[uint64][]byte
+	next      uint64
+	confirmed []byte
+}
+
+func newPosition(saved []byte) *position {
+	return &position{finished: make(map[uint64][]byte), confirmed: saved}
+}
+
+func (p *position) mark(position []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.marked = append(p.marked, bytes.Clone(position))
+}
+
+func (p *position) take() []byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.marked) == 0 {
+		return nil
+	}
+
+	head := p.marked[0]
+	p.marked = p.marked[1:]
+	return head
+}
+
+/*
+Mark the message at index, which had position, as having made it through
+*/
+func (p *position) finish(index uint64, position []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.finished[index] = position
+	for {
+		head, ok := p.finished[p.next]
+		if !ok {
+			return
+		}
+
+		delete(p.finished, p.next)
+		p.next++
+		if head != nil {
+			p.confirmed = head
+		}
+	}
+}
+
+func (p *position) current() []byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.confirmed
+}
+
+/*
+Read every source into one channel of messages, numbered by source, until they're
+exhausted or stopped is closed. Errors from sources are passed to report
+*/
+func readSources(sources []*source, stopped <-chan struct{}, report func(error)) <-chan message {
+	out := make(chan message)
+	wg := new(sync.WaitGroup)
+	for _, src := range sources {
+		send, errs := make(chan []byte), make(chan error)
+		go recoverProducer(src.producer)(send, errs)
+		go func(src *source) {
+			for err := range errs {
+				if err == nil {
+					continue
+				}
+
+				if src.stats != nil {
+					src.stats.Errored.Add(1)
+				}
+
+				if src.stage != "" {
+					err = &StageError{Stage: src.stage, Err: err}
+				}
+
+				report(fmt.Errorf("producer supplied error: %w", err))
+			}
+		}(src)
+
+		wg.Add(1)
+		go func(src *source) {
+			defer wg.Done()
+			for index := uint64(0); ; index++ {
+				select {
+				case <-stopped:
+					return
+				case data, ok := <-send:
+					if !ok {
+						return
+					}
+
+					msg := message{data: data, source: src, index: index, produced: time.Now()}
+					if src.position != nil {
+						msg.position = src.position.take()
+					}
+
+					if src.stats != nil {
+						src.stats.Produced.Add(1)
+					}
+
+					select {
+					case out <- msg:
+					case <-stopped:
+						return
+					}
+				}
+			}
+		}(src)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+/*
+A message waiting to be confirmed until the consumer has let go of the first
+until messages delivered to it
+*/
+type confirmation struct {
+	msg   message
+	until uint64
+}
+
+/*
+Messages waiting on the consumer, with handed as its handoff, before they're
+confirmed. Messages are waited on in the order they're delivered, so that each
+waits on at least as many messages as the one before it
+*/
+type confirmations struct {
+	lock    sync.Mutex
+	handed  handoff
+	waiting []confirmation
+}
+
+/*
+Wait on the consumer to let go of the first until messages delivered to it before
+confirming msg. Messages from a source that isn't resumable have nothing to
+confirm, so they aren't kept
+*/
+func (c *confirmations) wait(msg message, until uint64) {
+	if msg.source == nil || msg.source.position == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.waiting = append(c.waiting, confirmation{message{source: msg.source, index: msg.index, position: msg.position}, until})
+}
+
+/*
+Confirm every message that the consumer has let go of enough messages for
+*/
+func (c *confirmations) confirm() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	handed := uint64(math.MaxUint64)
+	if c.handed != nil {
+		handed = c.handed()
+	}
+
+	for len(c.waiting) != 0 && c.waiting[0].until <= handed {
+		msg := c.waiting[0].msg
+		msg.source.position.finish(msg.index, msg.position)
+		c.waiting = c.waiting[1:]
+	}
+}
+
+/*
+Saves the confirmed positions of resumable sources to a store, skipping any
+that haven't moved since they were last saved. Once the final positions are
+saved, nothing else is, so that a run can't save over those of the run after it
+*/
+type checkpointer struct {
+	lock    sync.Mutex
+	store   *checkpoint.Store
+	sources []*source
+	saved   map[*source][]byte
+	closed  bool
+}
+
+func newCheckpointer(store *checkpoint.Store, sources []*source) *checkpointer {
+	resumable := make([]*source, 0, len(sources))
+	for _, src := range sources {
+		if src.position != nil {
+			resumable = append(resumable, src)
+		}
+	}
+
+	return &checkpointer{store: store, sources: resumable, saved: make(map[*source][]byte)}
+}
+
+func (c *checkpointer) save(final bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+
+	c.closed = final
+	for _, src := range c.sources {
+		position := src.position.current()
+		if position == nil || bytes.Equal(position, c.saved[src]) {
+			continue
+		}
+
+		if err := c.store.Save(src.stage, position); err != nil {
+			return err
+		}
+
+		c.saved[src] = position
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_position(test *testing.T) {
+	tracked := newPosition([]byte("saved"))
+	for i := 1; i <= 4; i++ {
+		tracked.mark([]byte(fmt.Sprint(i)))
+	}
+
+	positions := make([][]byte, 4)
+	for i := range positions {
+		positions[i] = tracked.take()
+	}
+
+	assert.Nil(test, tracked.take())
+
+	tracked.finish(1, positions[1])
+	assert.Equal(test, []byte("saved"), tracked.current(), "nothing is confirmed until the first message is")
+
+	tracked.finish(0, positions[0])
+	assert.Equal(test, []byte("2"), tracked.current())
+
+	tracked.finish(3, positions[3])
+	tracked.finish(2, positions[2])
+	assert.Equal(test, []byte("4"), tracked.current())
+}
+
+func Test_RunPipeline_checkpoint(test *testing.T) {
+	dir := test.TempDir()
+	literal := fmt.Sprintf(`
+	produce "increment" "count" {
+		stop-after = 10
+	}
+	consume "trash" "bin" {}
+	pipeline "test" {
+		produce = [produce.increment.count]
+		consume = [consume.trash.bin]
+		transform = []
+		stop-after = 4
+		checkpoint {
+			dir = %q
+		}
+	}
+	`, dir)
+
+	run := func(stopAfter int) *Result {
+		descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		descriptors["test"].StopAfter = stopAfter
+		pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary(nil))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		result, err := RunPipeline(pipeline)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		return result
+	}
+
+	assert.Equal(test, 4, run(4).Produced)
+	saved, err := os.ReadFile(filepath.Join(dir, "produce.increment.count"))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, "4", string(saved))
+	assert.Equal(test, 6, run(0).Produced, "the second run should resume after the first 4")
+	assert.Equal(test, 0, run(0).Produced, "the third run should have nothing left")
+}
+
+func Test_RunPipeline_checkpointCrash(test *testing.T) {
+	dir, resumed, release := test.TempDir(), test.TempDir(), make(chan struct{})
+	lock, received := new(sync.Mutex), []int{}
+	receive := func(stuck bool) sdk.Consumer {
+		return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+			for batches := 0; ; batches++ {
+				if stuck && batches == 2 {
+					<-release
+				}
+
+				data, ok := <-recv
+				if !ok {
+					break
+				}
+
+				batch := []string{}
+				if err := json.Unmarshal(data, &batch); err != nil {
+					test.Error(err)
+				}
+
+				lock.Lock()
+				for _, each := range batch {
+					received = append(received, int(each[0]))
+				}
+
+				lock.Unlock()
+			}
+
+			close(done)
+			close(errs)
+		}
+	}
+
+	plugin := &sdk.Plugin{
+		Name: "test",
+		Resources: []*sdk.Resource{
+			{
+				Name:            "stuck",
+				Kinds:           sdk.CONSUMER,
+				ProvideConsumer: func(sdk.Parser) (sdk.Consumer, error) { return receive(true), nil },
+			},
+			{
+				Name:            "collect",
+				Kinds:           sdk.CONSUMER,
+				ProvideConsumer: func(sdk.Parser) (sdk.Consumer, error) { return receive(false), nil },
+			},
+		},
+	}
+
+	build := func(consumer, dir string) *Pipeline {
+		literal := fmt.Sprintf(`
+		produce "increment" "count" {
+			stop-after = 20
+		}
+		consume %q "c" {
+			buffer {
+				size = 4
+			}
+		}
+		pipeline "test" {
+			produce = [produce.increment.count]
+			consume = [consume.%s.c]
+			transform = []
+			batch {
+				size = 3
+			}
+			checkpoint {
+				dir = %q
+				interval = "10ms"
+			}
+		}
+		`, consumer, consumer, dir)
+
+		descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{plugin}))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		return pipeline
+	}
+
+	ran := make(chan error)
+	go func() {
+		_, err := RunPipeline(build("stuck", dir))
+		ran <- err
+	}()
+
+	// the consumer stops after 2 batches, while more wait in its buffer and batch
+	saved := filepath.Join(dir, "produce.increment.count")
+	assert.Eventually(test, func() bool {
+		position, _ := os.ReadFile(saved)
+		return string(position) == "6"
+	}, time.Second, time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	position, err := os.ReadFile(saved)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, "6", string(position), "nothing past what the consumer was handed is saved")
+
+	// killing the run here leaves only what's been saved so far to resume from
+	if err := os.WriteFile(filepath.Join(resumed, "produce.increment.count"), position, 0o644); err != nil {
+		test.Fatal(err)
+	}
+
+	lock.Lock()
+	assert.Equal(test, []int{0, 1, 2, 3, 4, 5}, received)
+	received = received[:0]
+	lock.Unlock()
+
+	if _, err := RunPipeline(build("collect", resumed)); err != nil {
+		test.Fatal(err)
+	}
+
+	want := make([]int, 0, 14)
+	for i := 6; i < 20; i++ {
+		want = append(want, i)
+	}
+
+	lock.Lock()
+	assert.Equal(test, want, received, "the resumed run picks up right after what the consumer was handed")
+	lock.Unlock()
+
+	close(release)
+	if err := <-ran; err != nil {
+		test.Fatal(err)
+	}
+}
+
+func Test_RunPipeline_checkpointWindow(test *testing.T) {
+	dir, release, received := test.TempDir(), make(chan struct{}), []string{}
+	plugin := collectPlugin(&received, &sdk.Resource{
+		Name:  "idle",
+		Kinds: sdk.PRODUCER,
+		ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+			return func(send chan<- []byte, errs chan<- error) {
+				<-release
+				close(send)
+				close(errs)
+			}, nil
+		},
+	})
+
+	literal := fmt.Sprintf(`
+	produce "increment" "count" {
+		stop-after = 6
+	}
+	produce "idle" "i" {}
+	transform "window" "w" {
+		count = 4
+	}
+	consume "collect" "c" {}
+	pipeline "test" {
+		produce = [produce.increment.count, produce.idle.i]
+		transform = [transform.window.w]
+		consume = [consume.collect.c]
+		checkpoint {
+			dir = %q
+			interval = "10ms"
+		}
+	}
+	`, dir)
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	pipeline, err := BuildPipeline(descriptors["test"], evalCtx, NewLibrary([]*sdk.Plugin{plugin}))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	ran := make(chan error)
+	go func() {
+		_, err := RunPipeline(pipeline)
+		ran <- err
+	}()
+
+	// the first window closes over 4 messages, and the last 2 are held in the next
+	saved := filepath.Join(dir, "produce.increment.count")
+	assert.Eventually(test, func() bool {
+		position, _ := os.ReadFile(saved)
+		return string(position) == "4"
+	}, 3*flushInterval, time.Millisecond)
+
+	time.Sleep(flushInterval + 50*time.Millisecond)
+	position, err := os.ReadFile(saved)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, "4", string(position), "messages held in an open window aren't saved")
+
+	close(release)
+	if err := <-ran; err != nil {
+		test.Fatal(err)
+	}
+
+	position, err = os.ReadFile(saved)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, "6", string(position))
+	assert.Len(test, received, 2)
+}
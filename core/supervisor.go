@@ -0,0 +1,218 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+A pipeline being run by a supervisor, and what it was built from
+*/
+type supervised struct {
+	run        *Run
+	descriptor *configure.Pipeline
+	evalCtx    *hcl.EvalContext
+	library    Library
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+func (entry *supervised) stop() {
+	entry.cancel()
+	<-entry.done
+}
+
+/*
+Keeps a set of pipelines running until ctx is done, letting the set be reloaded
+from new descriptors while they run. DrainTimeout, if set, overrides that of
+every pipeline built
+*/
+type Supervisor struct {
+	DrainTimeout time.Duration
+	ctx          context.Context
+	logger       *logrus.Logger
+	lock         sync.Mutex
+	reloading    sync.Mutex
+	pipelines    map[string]*supervised
+	finished     []*Run
+	running      sync.WaitGroup
+}
+
+func NewSupervisor(ctx context.Context) *Supervisor {
+	return &Supervisor{ctx: ctx, logger: pipelineLogger(), pipelines: make(map[string]*supervised)}
+}
+
+/*
+Build a pipeline from descriptor and start running it
+*/
+func (s *Supervisor) start(name string, descriptor *configure.Pipeline, evalCtx *hcl.EvalContext, library Library) (*supervised, error) {
+	pipeline, err := BuildPipeline(descriptor, evalCtx, library)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.DrainTimeout != 0 {
+		pipeline.DrainTimeout = s.DrainTimeout
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	entry := &supervised{
+		run:        &Run{Name: name, Pipeline: pipeline},
+		descriptor: descriptor,
+		evalCtx:    evalCtx,
+		library:    library,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer close(entry.done)
+		run := entry.run
+		run.Result, run.Err = RunPipelineContext(ctx, pipeline)
+		if run.Err != nil {
+			s.logger.Errorf("pipeline %s failed: %s", name, run.Err)
+		} else {
+			s.logger.Infof("pipeline %s %s after %d messages with %d errors", name, run.Result.Reason, run.Result.Produced, run.Result.Errors)
+		}
+
+		s.lock.Lock()
+		s.finished = append(s.finished, run)
+		s.lock.Unlock()
+	}()
+
+	return entry, nil
+}
+
+/*
+Make the pipelines of descriptors the ones running. Those whose digest hasn't
+changed are left alone, even if they've finished. Those that have changed have
+their old run stopped, draining what it has in flight and saving its state,
+before the new one is built and started, so that it picks up where the old one
+left off. Those missing from descriptors are stopped. A pipeline that fails to
+build has its old version built and started again, and the errors of every one
+that failed are returned together
+*/
+func (s *Supervisor) Reload(descriptors map[string]*configure.Pipeline, evalCtx *hcl.EvalContext, library Library) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	names := make([]string, 0, len(descriptors))
+	for name := range descriptors {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	failed := make([]error, 0)
+	for _, name := range names {
+		descriptor := descriptors[name]
+		s.lock.Lock()
+		old, ok := s.pipelines[name]
+		s.lock.Unlock()
+		if ok && old.descriptor.Digest == descriptor.Digest {
+			continue
+		}
+
+		if ok {
+			s.logger.Infof("%s changed, swapping it once it's drained", name)
+			old.stop()
+		}
+
+		entry, err := s.start(name, descriptor, evalCtx, library)
+		if err != nil && ok {
+			s.logger.Warnf("%s failed to build, starting its old version again", name)
+			var restartErr error
+			if entry, restartErr = s.start(name, old.descriptor, old.evalCtx, old.library); restartErr != nil {
+				err = fmt.Errorf("%s, and its old version failed to start again: %s", err, restartErr)
+				s.lock.Lock()
+				delete(s.pipelines, name)
+				s.lock.Unlock()
+			}
+		}
+
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %s", name, err))
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		s.lock.Lock()
+		s.pipelines[name] = entry
+		s.lock.Unlock()
+	}
+
+	s.lock.Lock()
+	removed := make([]*supervised, 0)
+	for name, entry := range s.pipelines {
+		if _, ok := descriptors[name]; !ok {
+			s.logger.Infof("%s was removed, stopping it", name)
+			removed = append(removed, entry)
+			delete(s.pipelines, name)
+		}
+	}
+
+	s.lock.Unlock()
+	for _, entry := range removed {
+		entry.stop()
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d pipelines failed to build: %w", len(failed), len(descriptors), errors.Join(failed...))
+}
+
+/*
+Serve the metrics of the pipelines running right now as prometheus text
+*/
+func (s *Supervisor) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		metrics := make([]*Metrics, 0, len(s.pipelines))
+		for _, entry := range s.pipelines {
+			metrics = append(metrics, entry.run.Pipeline.Metrics)
+		}
+
+		s.lock.Unlock()
+		MetricsHandler(metrics...).ServeHTTP(w, r)
+	})
+}
+
+/*
+Wait for ctx to be done and every run to drain, giving every run that was made
+in the order they finished, and an error joining those of runs that failed
+*/
+func (s *Supervisor) Wait() ([]*Run, error) {
+	<-s.ctx.Done()
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	s.running.Wait()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	failed := make([]error, 0)
+	for _, run := range s.finished {
+		if run.Err != nil {
+			failed = append(failed, fmt.Errorf("%s: %s", run.Name, run.Err))
+		}
+	}
+
+	if len(failed) == 0 {
+		return s.finished, nil
+	}
+
+	return s.finished, fmt.Errorf("%d of %d runs failed: %w", len(failed), len(s.finished), errors.Join(failed...))
+}
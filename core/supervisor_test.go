@@ -0,0 +1,193 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Supervisor_Reload(test *testing.T) {
+	endless := &sdk.Plugin{
+		Name: "test",
+		Resources: []*sdk.Resource{{
+			Name:  "endless",
+			Kinds: sdk.PRODUCER,
+			ProvideProducer: func(sdk.Parser) (sdk.Producer, error) {
+				return func(send chan<- []byte, errs chan<- error) {
+					for {
+						send <- []byte("cat")
+						time.Sleep(time.Millisecond)
+					}
+				}, nil
+			},
+		}},
+	}
+
+	literal := `
+	produce "endless" "p" {}
+	consume "trash" "c" {}
+	transform "sprintf" "b" {
+		format = "%s"
+		encoding = "string"
+	}
+	pipeline "a" {
+		produce = [produce.endless.p]
+		consume = [consume.trash.c]
+		transform = []
+	}
+	pipeline "b" {
+		produce = [produce.endless.p]
+		consume = [consume.trash.c]
+		transform = [transform.sprintf.b]
+	}
+	`
+
+	library := NewLibrary([]*sdk.Plugin{endless})
+	reload := func(supervisor *Supervisor, literal string) error {
+		descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		return supervisor.Reload(descriptors, evalCtx, library)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	supervisor := NewSupervisor(ctx)
+	if err := reload(supervisor, literal); err != nil {
+		test.Fatal(err)
+	}
+
+	a, b := supervisor.pipelines["a"], supervisor.pipelines["b"]
+	if err := reload(supervisor, literal); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Same(test, a, supervisor.pipelines["a"], "nothing changed")
+	assert.Same(test, b, supervisor.pipelines["b"], "nothing changed")
+
+	changed := strings.Replace(literal, `format = "%s"`, `format = "%s!"`, 1)
+	if err := reload(supervisor, changed); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Same(test, a, supervisor.pipelines["a"], "a doesn't use what changed")
+	assert.NotSame(test, b, supervisor.pipelines["b"])
+	assert.Equal(test, StopCancelled, b.run.Result.Reason, "the old b was stopped")
+
+	b = supervisor.pipelines["b"]
+	broken := strings.Replace(changed, `encoding = "string"`, `encoding = "morse"`, 1)
+	err := reload(supervisor, broken)
+	if err == nil {
+		test.Fatal("expected an error building b")
+	}
+
+	assert.Contains(test, err.Error(), "b: ")
+	assert.NotSame(test, b, supervisor.pipelines["b"], "the old b was stopped before the new one was built")
+	assert.Equal(test, b.descriptor.Digest, supervisor.pipelines["b"].descriptor.Digest, "the old b is started again")
+
+	removed := strings.Replace(changed, `pipeline "b" {`, `pipeline "c" {`, 1)
+	if err := reload(supervisor, removed); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.NotContains(test, supervisor.pipelines, "b")
+	assert.Contains(test, supervisor.pipelines, "c")
+
+	cancel()
+	runs, err := supervisor.Wait()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Len(test, runs, 5)
+	for _, run := range runs {
+		assert.Equal(test, StopCancelled, run.Result.Reason)
+	}
+}
+
+func Test_Supervisor_Reload_checkpoint(test *testing.T) {
+	lock, received := new(sync.Mutex), []int{}
+	slow := &sdk.Plugin{
+		Name: "test",
+		Resources: []*sdk.Resource{{
+			Name:  "slow",
+			Kinds: sdk.CONSUMER,
+			ProvideConsumer: func(sdk.Parser) (sdk.Consumer, error) {
+				return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+					for data := range recv {
+						time.Sleep(2 * time.Millisecond)
+						lock.Lock()
+						received = append(received, int(data[0]))
+						lock.Unlock()
+					}
+
+					close(done)
+					close(errs)
+				}, nil
+			},
+		}},
+	}
+
+	literal := fmt.Sprintf(`
+	produce "increment" "count" {
+		stop-after = 200
+	}
+	consume "slow" "c" {}
+	pipeline "test" {
+		produce = [produce.increment.count]
+		consume = [consume.slow.c]
+		transform = []
+		max-errors = 1
+		checkpoint {
+			dir = %q
+		}
+	}
+	`, test.TempDir())
+
+	library := NewLibrary([]*sdk.Plugin{slow})
+	reload := func(supervisor *Supervisor, literal string) {
+		descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if err := supervisor.Reload(descriptors, evalCtx, library); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	supervisor := NewSupervisor(ctx)
+	reload(supervisor, literal)
+	time.Sleep(50 * time.Millisecond)
+	reload(supervisor, strings.Replace(literal, "max-errors = 1", "max-errors = 2", 1))
+
+	run := supervisor.pipelines["test"].run
+	assert.Eventually(test, func() bool {
+		supervisor.lock.Lock()
+		defer supervisor.lock.Unlock()
+		return len(supervisor.finished) == 2
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	if _, err := supervisor.Wait(); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Equal(test, StopExhausted, run.Result.Reason)
+	want := make([]int, 200)
+	for i := range want {
+		want[i] = i
+	}
+
+	assert.Equal(test, want, received, "the new run picks up where the old one was drained to")
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/hashicorp/hcl/v2"
+)
+
+/*
+Check every resource that descriptors use against the spec of the plugin
+resource it's of, without providing any of them. Resources are checked once
+however many pipelines use them, and every problem found is returned
+*/
+func ValidatePipelines(descriptors []*configure.Pipeline, evalCtx *hcl.EvalContext, library Library) hcl.Diagnostics {
+	checked, diags := make(map[string]bool), hcl.Diagnostics{}
+	for _, descriptor := range descriptors {
+		for _, use := range descriptor.Uses() {
+			if ref := use.Ref(); !checked[ref] {
+				checked[ref] = true
+				diags = diags.Extend(library.Validate(use.Namespace, use.Part.Kind, evalCtx, use.Part.Options))
+			}
+		}
+	}
+
+	return diags
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/psyduck-etl/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValidatePipelines(test *testing.T) {
+	provided := false
+	plugin := &sdk.Plugin{
+		Name: "test",
+		Resources: []*sdk.Resource{{
+			Name:  "collect",
+			Kinds: sdk.CONSUMER,
+			ProvideConsumer: func(sdk.Parser) (sdk.Consumer, error) {
+				provided = true
+				return nil, nil
+			},
+		}},
+	}
+
+	literal := `
+	produce "constant" "cat" {
+		value = "cat"
+		stop-after = "twice"
+	}
+	produce "collect" "wrong" {}
+	transform "sprintf" "loud" {
+		format = "%s!"
+		encoding = "string"
+		loudness = 11
+	}
+	transform "sprintf" "quiet" {
+		format = null
+	}
+	consume "collect" "c" {}
+	consume "missing" "c" {}
+	pipeline "a" {
+		produce = [produce.constant.cat]
+		transform = [transform.sprintf.loud]
+		consume = [consume.collect.c]
+	}
+	pipeline "b" {
+		produce = [produce.constant.cat, produce.collect.wrong]
+		transform = [transform.sprintf.quiet]
+		consume = [consume.missing.c]
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	diags := ValidatePipelines([]*configure.Pipeline{descriptors["a"], descriptors["b"]}, evalCtx, NewLibrary([]*sdk.Plugin{plugin}))
+	assert.False(test, provided, "nothing is provided while validating")
+
+	summaries := make([]string, len(diags))
+	for index, diag := range diags {
+		summaries[index] = diag.Summary
+		assert.NotNil(test, diag.Subject, "%s has a range", diag.Summary)
+	}
+
+	assert.ElementsMatch(test, []string{"invalid primitive type", "Unsupported argument", "value required", "wrong kind of resource", "unknown resource"}, summaries)
+	for _, diag := range diags {
+		if diag.Summary == "invalid primitive type" {
+			assert.Equal(test, 4, diag.Subject.Start.Line)
+		}
+
+		if diag.Summary == "value required" {
+			assert.Equal(test, 12, diag.Subject.Start.Line, "reported at the block missing it")
+		}
+
+		if diag.Summary == "Unsupported argument" {
+			assert.Equal(test, hcl.DiagWarning, diag.Severity)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+How many messages per worker may be held back waiting on a slower one when
+output has to stay ordered
+*/
+const reorderWindow = 4
+
+/*
+A message moving through a pipeline, numbered in the order it was produced and
+carrying what's known about where it came from. It's also numbered by the source
+it came from, along with the position that source marked for it if it's resumable.
+Once transformed, what it turned into is kept in outputs
+*/
+type message struct {
+	seq      uint64
+	data     []byte
+	outputs  [][]byte
+	source   *source
+	index    uint64
+	position []byte
+	produced time.Time
+	headers  map[string]string
+
+	// when the message made it through the transformers
+	transformed time.Time
+
+	// made by flushing the transformers rather than from a produced message, so it
+	// has no seq of its own
+	flushed bool
+}
+
+func spawnWorkers(workers int, transform func(message) message, in <-chan message) <-chan message {
+	out := make(chan message)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range in {
+				out <- transform(msg)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+/*
+Emit messages from in ordered by seq, starting at 0. Every emitted message frees
+a slot in window so that more may be admitted upstream
+*/
+func reorder(in <-chan message, window <-chan struct{}) <-chan message {
+	out := make(chan message)
+	go func() {
+		defer close(out)
+
+		next, pending := uint64(0), make(map[uint64]message)
+		for msg := range in {
+			pending[msg.seq] = msg
+			for {
+				head, ok := pending[next]
+				if !ok {
+					break
+				}
+
+				delete(pending, next)
+				next++
+				<-window
+				out <- head
+			}
+		}
+	}()
+
+	return out
+}
+
+/*
+Apply transform to every message from in on workers goroutines.
+
+If ordered, messages come out in the order they went in. Filtered messages still
+come out ( with no outputs ) so that they can be accounted for
+*/
+func parallelTransform(workers int, ordered bool, transform func(message) message, in <-chan message) <-chan message {
+	if workers <= 1 {
+		return spawnWorkers(1, transform, in)
+	}
+
+	if !ordered {
+		return spawnWorkers(workers, transform, in)
+	}
+
+	window := make(chan struct{}, workers*reorderWindow)
+	admitted := make(chan message)
+	go func() {
+		defer close(admitted)
+		for msg := range in {
+			window <- struct{}{}
+			admitted <- msg
+		}
+	}()
+
+	return reorder(spawnWorkers(workers, transform, admitted), window)
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func feed(count int) <-chan message {
+	in := make(chan message)
+	go func() {
+		for i := 0; i < count; i++ {
+			in <- message{seq: uint64(i), data: []byte{byte(i)}}
+		}
+
+		close(in)
+	}()
+
+	return in
+}
+
+func jitter(msg message) message {
+	for i := rand.Intn(100); i > 0; i-- {
+		runtime.Gosched()
+	}
+
+	return msg
+}
+
+func Test_parallelTransform(test *testing.T) {
+	const count = 200
+
+	testcases := [...]struct {
+		workers int
+		ordered bool
+	}{{1, true}, {1, false}, {8, true}, {8, false}}
+
+	for i, testcase := range testcases {
+		seen, inOrder, next := make(map[uint64]bool, count), true, uint64(0)
+		for msg := range parallelTransform(testcase.workers, testcase.ordered, jitter, feed(count)) {
+			seen[msg.seq] = true
+			inOrder = inOrder && msg.seq == next
+			next++
+		}
+
+		if len(seen) != count {
+			test.Fatalf("case %d: saw %d messages, wanted %d", i, len(seen), count)
+		}
+
+		if testcase.ordered && !inOrder {
+			test.Fatalf("case %d: messages came out of order", i)
+		}
+	}
+}
+
+func Test_parallelTransform_filtered(test *testing.T) {
+	const count = 100
+
+	filterOdd := func(msg message) message {
+		if msg.seq%2 == 1 {
+			msg.data = nil
+			return msg
+		}
+
+		return jitter(msg)
+	}
+
+	kept, next := 0, uint64(0)
+	for msg := range parallelTransform(4, true, filterOdd, feed(count)) {
+		if msg.seq != next {
+			test.Fatalf("got seq %d, wanted %d", msg.seq, next)
+		}
+
+		if msg.data != nil {
+			kept++
+		}
+
+		next++
+	}
+
+	if kept != count/2 {
+		test.Fatalf("kept %d, wanted %d", kept, count/2)
+	}
+}
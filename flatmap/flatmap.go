@@ -0,0 +1,40 @@
+package flatmap
+
+import "time"
+
+/*
+Implemented by the config of a transformer that may turn one message into any
+number of them, including none.
+
+An sdk.Transformer can only return a single message, so when the parsed config
+of a transformer is a FlatMapper, psyduck calls FlatMap in place of the
+transformer that was provided
+*/
+type FlatMapper interface {
+	FlatMap(data []byte) ([][]byte, error)
+}
+
+/*
+Implemented by the config of a transformer that holds messages back, such as one
+that aggregates them.
+
+Flush is called every so often with the current time, giving whatever the
+transformer is done holding onto, and once more with final set after the last
+message, giving everything it still has. It may be called while FlatMap is
+*/
+type Flusher interface {
+	Flush(now time.Time, final bool) ([][]byte, error)
+}
+
+/*
+Implemented by the config of a Flusher that can say since when it's been holding
+onto what it has yet to flush: when it was given the earliest message it still
+holds, or the zero time if it holds nothing.
+
+A checkpoint only moves past a message once it's been through every Flusher of
+the pipeline before what they hold was given to them. As a Flusher that isn't a
+Holder can't say, messages it's given are only checkpointed after the final flush
+*/
+type Holder interface {
+	HeldSince() time.Time
+}
@@ -0,0 +1,253 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+/*
+A resource as used by one pipeline. Role is the attribute of the pipeline that
+uses it, like produce, route or produce-from. The same resource may be used more
+than once by an attribute, as with a transformer twice in a chain, so ID has its
+position among the attribute's uses as well
+*/
+type Node struct {
+	ID       string `json:"id"`
+	Pipeline string `json:"pipeline"`
+	Ref      string `json:"ref"`
+	Role     string `json:"role"`
+}
+
+/*
+Messages moving from one node to another. Edges between pipelines are Shared,
+going from a consumer to a producer that reads what it writes
+*/
+type Edge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Label  string `json:"label,omitempty"`
+	Shared bool   `json:"shared,omitempty"`
+}
+
+/*
+How messages move through and between a set of pipelines
+*/
+type Graph struct {
+	Pipelines []string `json:"pipelines"`
+	Nodes     []*Node  `json:"nodes"`
+	Edges     []*Edge  `json:"edges"`
+}
+
+/*
+The string attributes of a resource's block, which are what say where a
+resource like a queue reads or writes
+*/
+func stringOptions(body hcl.Body, evalCtx *hcl.EvalContext) map[string]string {
+	attributes, _ := body.JustAttributes()
+	options := make(map[string]string, len(attributes))
+	for name, attribute := range attributes {
+		value, diags := attribute.Expr.Value(evalCtx)
+		if diags.HasErrors() || !value.IsKnown() || value.IsNull() || !value.Type().Equals(cty.String) {
+			continue
+		}
+
+		options[name] = value.AsString()
+	}
+
+	return options
+}
+
+/*
+Whether a consumer writes to where a producer reads, as with both ends of a
+queue. They must be of the same resource, and share a string option, with every
+string option they share being the same
+*/
+func shares(consumer, producer *configure.Use, options map[*configure.Use]map[string]string) bool {
+	if consumer.Part.Kind != producer.Part.Kind {
+		return false
+	}
+
+	shared := 0
+	for name, value := range options[consumer] {
+		if other, ok := options[producer][name]; ok {
+			if other != value {
+				return false
+			}
+
+			shared++
+		}
+	}
+
+	return shared != 0
+}
+
+/*
+The ref of node, along with its role if it's not plain from the ref
+*/
+func (node *Node) label(separator string) string {
+	switch node.Role {
+	case "produce", "transform", "consume":
+		return node.Ref
+	default:
+		return node.Role + separator + node.Ref
+	}
+}
+
+/*
+Graph descriptors, in the order given. Each pipeline's producers lead through its
+transformers in order to its consumers, and a consumer of one pipeline leads to
+the producers of others that share its queue-like options
+*/
+func Build(descriptors []*configure.Pipeline, evalCtx *hcl.EvalContext) *Graph {
+	graph := &Graph{Pipelines: make([]string, len(descriptors)), Nodes: make([]*Node, 0), Edges: make([]*Edge, 0)}
+	sources, sinks := make(map[*Node]*configure.Use), make(map[*Node]*configure.Use)
+	options := make(map[*configure.Use]map[string]string)
+	for index, descriptor := range descriptors {
+		graph.Pipelines[index] = descriptor.Name
+		// remote transformers come after local ones, whatever order they're named in
+		var from, chain, remote, to []*Node
+		positions := make(map[string]int)
+		for _, use := range descriptor.Uses() {
+			id := fmt.Sprintf("%s/%s/%d/%s", descriptor.Name, use.Attribute, positions[use.Attribute], use.Ref())
+			positions[use.Attribute]++
+			node := &Node{ID: id, Pipeline: descriptor.Name, Ref: use.Ref(), Role: use.Attribute}
+			graph.Nodes = append(graph.Nodes, node)
+			options[use] = stringOptions(use.Part.Options, evalCtx)
+			switch use.Attribute {
+			case "produce", "produce-from":
+				from, sources[node] = append(from, node), use
+			case "transform":
+				chain = append(chain, node)
+			case "transform-from":
+				remote = append(remote, node)
+			case "consume", "route", "dead-letter":
+				to, sinks[node] = append(to, node), use
+			default:
+				to = append(to, node)
+			}
+		}
+
+		for _, node := range append(chain, remote...) {
+			for _, previous := range from {
+				graph.Edges = append(graph.Edges, &Edge{From: previous.ID, To: node.ID})
+			}
+
+			from = []*Node{node}
+		}
+
+		for _, node := range to {
+			label := ""
+			if node.Role != "consume" {
+				label = node.Role
+			}
+
+			for _, previous := range from {
+				graph.Edges = append(graph.Edges, &Edge{From: previous.ID, To: node.ID, Label: label})
+			}
+		}
+	}
+
+	for _, consumer := range graph.Nodes {
+		for _, producer := range graph.Nodes {
+			if sinks[consumer] == nil || sources[producer] == nil || consumer.Pipeline == producer.Pipeline {
+				continue
+			}
+
+			if shares(sinks[consumer], sources[producer], options) {
+				graph.Edges = append(graph.Edges, &Edge{From: consumer.ID, To: producer.ID, Shared: true})
+			}
+		}
+	}
+
+	return graph
+}
+
+/*
+Write graph in the DOT language of graphviz, with a cluster for each pipeline
+*/
+func (graph *Graph) WriteDOT(w io.Writer) error {
+	out := new(strings.Builder)
+	fmt.Fprintln(out, "digraph psyduck {")
+	fmt.Fprintln(out, "  rankdir=LR;")
+	for index, pipeline := range graph.Pipelines {
+		fmt.Fprintf(out, "  subgraph cluster_%d {\n", index)
+		fmt.Fprintf(out, "    label=%q;\n", pipeline)
+		for _, node := range graph.Nodes {
+			if node.Pipeline != pipeline {
+				continue
+			}
+
+			fmt.Fprintf(out, "    %q [label=%q];\n", node.ID, node.label("\n"))
+		}
+
+		fmt.Fprintln(out, "  }")
+	}
+
+	for _, edge := range graph.Edges {
+		attributes := make([]string, 0, 2)
+		if edge.Label != "" {
+			attributes = append(attributes, fmt.Sprintf("label=%q", edge.Label))
+		}
+
+		if edge.Shared {
+			attributes = append(attributes, "style=dashed")
+		}
+
+		if len(attributes) == 0 {
+			fmt.Fprintf(out, "  %q -> %q;\n", edge.From, edge.To)
+		} else {
+			fmt.Fprintf(out, "  %q -> %q [%s];\n", edge.From, edge.To, strings.Join(attributes, ", "))
+		}
+	}
+
+	fmt.Fprintln(out, "}")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func mermaidText(text string) string {
+	return strings.ReplaceAll(text, `"`, "#quot;")
+}
+
+/*
+Write graph as a mermaid flowchart, with a subgraph for each pipeline
+*/
+func (graph *Graph) WriteMermaid(w io.Writer) error {
+	out, ids := new(strings.Builder), make(map[string]string, len(graph.Nodes))
+	for index, node := range graph.Nodes {
+		ids[node.ID] = fmt.Sprintf("n%d", index)
+	}
+
+	fmt.Fprintln(out, "flowchart LR")
+	for index, pipeline := range graph.Pipelines {
+		fmt.Fprintf(out, "  subgraph p%d [\"%s\"]\n", index, mermaidText(pipeline))
+		for _, node := range graph.Nodes {
+			if node.Pipeline != pipeline {
+				continue
+			}
+
+			fmt.Fprintf(out, "    %s[\"%s\"]\n", ids[node.ID], mermaidText(node.label(" ")))
+		}
+
+		fmt.Fprintln(out, "  end")
+	}
+
+	for _, edge := range graph.Edges {
+		switch {
+		case edge.Shared:
+			fmt.Fprintf(out, "  %s -.-> %s\n", ids[edge.From], ids[edge.To])
+		case edge.Label != "":
+			fmt.Fprintf(out, "  %s -- %s --> %s\n", ids[edge.From], mermaidText(edge.Label), ids[edge.To])
+		default:
+			fmt.Fprintf(out, "  %s --> %s\n", ids[edge.From], ids[edge.To])
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,150 @@
+package graph
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gastrodon/psyduck/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+const literal = `
+value {
+	queue = "left"
+}
+produce "constant" "c" {}
+produce "channel" "control" {
+	name = "control"
+}
+produce "channel" "left" {
+	name = value.queue
+}
+consume "channel" "left" {
+	name = "left"
+	size = 10
+}
+consume "channel" "right" {
+	name = "right"
+}
+consume "trash" "t" {}
+consume "trash" "dead" {}
+transform "inspect" "a" {}
+transform "inspect" "b" {}
+pipeline "load" {
+	produce = [produce.constant.c]
+	transform = [transform.inspect.a, transform.inspect.b]
+	consume = [consume.channel.left, consume.channel.right]
+	dead-letter = consume.trash.dead
+}
+pipeline "move" {
+	produce-from = produce.channel.left
+	transform = []
+	transform-from = produce.channel.control
+	consume = [consume.trash.t]
+}
+`
+
+func edges(graph *Graph) []string {
+	found := make([]string, len(graph.Edges))
+	for index, edge := range graph.Edges {
+		found[index] = edge.From + " -> " + edge.To
+		if edge.Label != "" {
+			found[index] += " " + edge.Label
+		}
+
+		if edge.Shared {
+			found[index] += " shared"
+		}
+	}
+
+	return found
+}
+
+func TestBuild(test *testing.T) {
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	graph := Build([]*configure.Pipeline{descriptors["load"], descriptors["move"]}, evalCtx)
+	assert.Equal(test, []string{"load", "move"}, graph.Pipelines)
+	assert.Len(test, graph.Nodes, 9)
+	assert.ElementsMatch(test, []string{
+		"load/produce/0/produce.constant.c -> load/transform/0/transform.inspect.a",
+		"load/transform/0/transform.inspect.a -> load/transform/1/transform.inspect.b",
+		"load/transform/1/transform.inspect.b -> load/consume/0/consume.channel.left",
+		"load/transform/1/transform.inspect.b -> load/consume/1/consume.channel.right",
+		"load/transform/1/transform.inspect.b -> load/dead-letter/0/consume.trash.dead dead-letter",
+		"move/produce-from/0/produce.channel.left -> move/transform-from/0/produce.channel.control",
+		"move/transform-from/0/produce.channel.control -> move/consume/0/consume.trash.t",
+		"load/consume/0/consume.channel.left -> move/produce-from/0/produce.channel.left shared",
+	}, edges(graph))
+
+	graph = Build([]*configure.Pipeline{descriptors["move"]}, evalCtx)
+	assert.Equal(test, []string{"move"}, graph.Pipelines)
+	assert.Len(test, graph.Edges, 2)
+}
+
+func TestGraph_write(test *testing.T) {
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	graph := Build([]*configure.Pipeline{descriptors["load"], descriptors["move"]}, evalCtx)
+	dot := new(strings.Builder)
+	if err := graph.WriteDOT(dot); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.True(test, strings.HasPrefix(dot.String(), "digraph psyduck {\n"))
+	assert.Contains(test, dot.String(), `    label="load";`)
+	assert.Contains(test, dot.String(), `"move/produce-from/0/produce.channel.left" [label="produce-from\nproduce.channel.left"];`)
+	assert.Contains(test, dot.String(), `"load/consume/0/consume.channel.left" -> "move/produce-from/0/produce.channel.left" [style=dashed];`)
+
+	mermaid := new(strings.Builder)
+	if err := graph.WriteMermaid(mermaid); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.True(test, strings.HasPrefix(mermaid.String(), "flowchart LR\n"))
+	assert.Contains(test, mermaid.String(), `  subgraph p1 ["move"]`)
+	assert.Contains(test, mermaid.String(), `    n0["produce.constant.c"]`)
+	assert.Contains(test, mermaid.String(), "  n3 -.-> n6\n")
+	assert.Contains(test, mermaid.String(), "  n2 -- dead-letter --> n5\n")
+}
+
+func TestBuild_repeated(test *testing.T) {
+	literal := `
+	produce "constant" "c" {}
+	consume "trash" "t" {}
+	transform "inspect" "a" {}
+	transform "inspect" "b" {}
+	pipeline "twice" {
+		produce = [produce.constant.c]
+		transform = [transform.inspect.a, transform.inspect.b, transform.inspect.a]
+		consume = [consume.trash.t]
+	}
+	`
+
+	descriptors, evalCtx, err := configure.Literal("test.psy", []byte(literal))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	graph := Build([]*configure.Pipeline{descriptors["twice"]}, evalCtx)
+	assert.Len(test, graph.Nodes, 5)
+	assert.Equal(test, []string{
+		"twice/produce/0/produce.constant.c -> twice/transform/0/transform.inspect.a",
+		"twice/transform/0/transform.inspect.a -> twice/transform/1/transform.inspect.b",
+		"twice/transform/1/transform.inspect.b -> twice/transform/2/transform.inspect.a",
+		"twice/transform/2/transform.inspect.a -> twice/consume/0/consume.trash.t",
+	}, edges(graph))
+
+	mermaid := new(strings.Builder)
+	if err := graph.WriteMermaid(mermaid); err != nil {
+		test.Fatal(err)
+	}
+
+	assert.Contains(test, mermaid.String(), "  n1 --> n2\n  n2 --> n3\n  n3 --> n4\n")
+}
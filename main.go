@@ -1,20 +1,43 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/gastrodon/psyduck/catalog"
 	"github.com/gastrodon/psyduck/configure"
 	"github.com/gastrodon/psyduck/core"
+	"github.com/gastrodon/psyduck/graph"
+	"github.com/gastrodon/psyduck/stdlib"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/psyduck-etl/sdk"
 	"github.com/urfave/cli/v2"
 )
 
 var NAME = "psyduck"
+
+// how often --watch checks for changed .psy files
+const watchInterval = time.Second
+
 var SUBCOMMANDS = [...]string{
 	"init",
 	"run",
+	"serve",
+	"validate",
+	"graph",
+	"resources",
+	"describe",
 }
 
 func cmdinit(ctx *cli.Context) error { // init is a different thing in go
@@ -48,40 +71,518 @@ func cmdinit(ctx *cli.Context) error { // init is a different thing in go
 	return os.WriteFile(path.Join(initPath, "plugin.json"), b, 0o644)
 }
 
+/*
+Read the pipelines of the workspace at --chdir, along with the library of plugins
+they're built from
+*/
+func workspace(ctx *cli.Context) (map[string]*configure.Pipeline, *hcl.EvalContext, core.Library, error) {
+	literal, err := configure.ReadDirectory(ctx.String("chdir"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	filename := path.Base(ctx.String("chdir"))
+	descriptors, evalCtx, err := configure.Literal(filename, literal)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	library, err := loadLibrary(ctx, literal, evalCtx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, descriptor := range descriptors {
+		if descriptor.Checkpoint != nil && !path.IsAbs(descriptor.Checkpoint.Dir) {
+			descriptor.Checkpoint.Dir = path.Join(ctx.String("chdir"), descriptor.Checkpoint.Dir)
+		}
+
+		if !path.IsAbs(descriptor.StateDir) {
+			descriptor.StateDir = path.Join(ctx.String("chdir"), descriptor.StateDir)
+		}
+	}
+
+	return descriptors, evalCtx, library, nil
+}
+
+/*
+The library of the plugins that literal, read from the workspace at --chdir,
+loads
+*/
+func loadLibrary(ctx *cli.Context, literal []byte, evalCtx *hcl.EvalContext) (core.Library, error) {
+	initPath := path.Join(ctx.String("chdir"), ".psyduck")
+	plugins, err := configure.LoadPlugins(initPath, path.Base(ctx.String("chdir")), literal, evalCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewLibrary(plugins), nil
+}
+
+/*
+A context that's done on the first interrupt, and doesn't catch the second
+*/
+func interruptible(ctx *cli.Context) (context.Context, func()) {
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-runCtx.Done()
+		stop() // a second signal while draining kills us outright
+	}()
+
+	return runCtx, stop
+}
+
+/*
+Serve handler at path on addr until the returned func is called
+*/
+func listen(addr, path string, handler http.Handler) func() error {
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "failed serving %s: %s\n", path, err)
+		}
+	}()
+
+	return server.Close
+}
+
+/*
+The names of the pipelines to run, being those named as args or, with --all,
+every one of descriptors
+*/
+func selectTargets(ctx *cli.Context, descriptors map[string]*configure.Pipeline) ([]string, error) {
+	targets := ctx.Args().Slice()
+	switch {
+	case ctx.Bool("all") && len(targets) != 0:
+		return nil, fmt.Errorf("can't name targets along with --all")
+	case ctx.Bool("all"):
+		for name := range descriptors {
+			targets = append(targets, name)
+		}
+
+		sort.Strings(targets)
+	case len(targets) == 0:
+		return nil, fmt.Errorf("target required")
+	}
+
+	for _, target := range targets {
+		if _, ok := descriptors[target]; !ok {
+			return nil, fmt.Errorf("can't find target %s", target)
+		}
+	}
+
+	return targets, nil
+}
+
+/*
+Call changed whenever a .psy file in directory is written, added or removed,
+checking every interval until ctx is done
+*/
+func watch(ctx context.Context, directory string, interval time.Duration, changed func()) {
+	snapshot := func() map[string]string {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			return nil
+		}
+
+		files := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".psy") {
+				continue
+			}
+
+			if info, err := entry.Info(); err == nil {
+				files[entry.Name()] = fmt.Sprintf("%s %d", info.ModTime(), info.Size())
+			}
+		}
+
+		return files
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := snapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if current := snapshot(); current != nil && !maps.Equal(current, last) {
+				last = current
+				changed()
+			}
+		}
+	}
+}
+
+/*
+Print how each run went, and the metrics of its stages
+*/
+func summarize(runs []*core.Run) {
+	for _, run := range runs {
+		if run.Result == nil {
+			continue
+		}
+
+		result := run.Result
+		fmt.Printf("pipeline %s %s after %d messages with %d errors in %s\n", run.Name, result.Reason, result.Produced, result.Errors, result.Elapsed.Round(time.Millisecond))
+		if result.Throttled != 0 {
+			fmt.Printf("spent %s throttled by rate limits\n", result.Throttled.Round(time.Millisecond))
+		}
+
+		run.Pipeline.Metrics.WriteSummary(os.Stdout)
+	}
+}
+
+/*
+Run pipelines until interrupted, rebuilding those that change whenever the .psy
+files of the workspace do. A reload that fails leaves what's running alone
+*/
+func runWatched(ctx *cli.Context) error {
+	if ctx.Bool("fail-fast") {
+		return fmt.Errorf("can't use --fail-fast along with --watch")
+	}
+
+	selected := func() (map[string]*configure.Pipeline, *hcl.EvalContext, core.Library, error) {
+		descriptors, evalCtx, library, err := workspace(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		names, err := selectTargets(ctx, descriptors)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		picked := make(map[string]*configure.Pipeline, len(names))
+		for _, name := range names {
+			picked[name] = descriptors[name]
+		}
+
+		return picked, evalCtx, library, nil
+	}
+
+	descriptors, evalCtx, library, err := selected()
+	if err != nil {
+		return err
+	}
+
+	runCtx, stop := interruptible(ctx)
+	defer stop()
+
+	supervisor := core.NewSupervisor(runCtx)
+	supervisor.DrainTimeout = ctx.Duration("drain-timeout")
+	if err := supervisor.Reload(descriptors, evalCtx, library); err != nil {
+		stop()
+		supervisor.Wait()
+		return err
+	}
+
+	if addr := ctx.String("metrics-addr"); addr != "" {
+		defer listen(addr, "/metrics", supervisor.MetricsHandler())()
+	}
+
+	go watch(runCtx, ctx.String("chdir"), watchInterval, func() {
+		descriptors, evalCtx, library, err := selected()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "not reloading: %s\n", err)
+			return
+		}
+
+		if err := supervisor.Reload(descriptors, evalCtx, library); err != nil {
+			fmt.Fprintf(os.Stderr, "failed reloading: %s\n", err)
+		}
+	})
+
+	runs, err := supervisor.Wait()
+	summarize(runs)
+	return err
+}
+
 func run(ctx *cli.Context) error {
+	if ctx.Bool("watch") {
+		return runWatched(ctx)
+	}
+
+	descriptors, evalCtx, library, err := workspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	targets, err := selectTargets(ctx, descriptors)
+	if err != nil {
+		return err
+	}
+
+	// every pipeline is built before any are run, so that those connected through
+	// a channel are all attached to it before it might close
+	runs := make([]*core.Run, len(targets))
+	metrics := make([]*core.Metrics, len(targets))
+	for i, target := range targets {
+		pipeline, err := core.BuildPipeline(descriptors[target], evalCtx, library)
+		if err != nil {
+			return fmt.Errorf("failed building %s: %s", target, err)
+		}
+
+		if ctx.IsSet("drain-timeout") {
+			pipeline.DrainTimeout = ctx.Duration("drain-timeout")
+		}
+
+		runs[i], metrics[i] = &core.Run{Name: target, Pipeline: pipeline}, pipeline.Metrics
+	}
+
+	runCtx, stop := interruptible(ctx)
+	defer stop()
+
+	if addr := ctx.String("metrics-addr"); addr != "" {
+		defer listen(addr, "/metrics", core.MetricsHandler(metrics...))()
+	}
+
+	err = core.RunPipelines(runCtx, runs, ctx.Bool("fail-fast"))
+	summarize(runs)
+	return err
+}
+
+/*
+The diagnostics that err carries, or one with the text of err if it carries none
+*/
+func diagnosticsOf(err error) hcl.Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	var diags hcl.Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+
+	return hcl.Diagnostics{{Severity: hcl.DiagError, Summary: "invalid configuration", Detail: err.Error()}}
+}
+
+/*
+Check every pipeline of the workspace against the specs of the resources it
+uses, without providing any of them, reporting every problem found at once
+*/
+func validate(ctx *cli.Context) error {
+	literal, sources, err := configure.ReadDirectorySources(ctx.String("chdir"))
+	if err != nil {
+		return err
+	}
+
+	pipelines := make([]*configure.Pipeline, 0)
+	descriptors, evalCtx, err := configure.Literal(path.Base(ctx.String("chdir")), literal)
+	diags := diagnosticsOf(err)
+	if err == nil {
+		names := make([]string, 0, len(descriptors))
+		for name := range descriptors {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			pipelines = append(pipelines, descriptors[name])
+		}
+
+		if library, err := loadLibrary(ctx, literal, evalCtx); err != nil {
+			diags = diagnosticsOf(err)
+		} else {
+			diags = core.ValidatePipelines(pipelines, evalCtx, library)
+		}
+	}
+
+	sources.LocateDiagnostics(diags)
+	failed := 0
+	for _, diag := range diags {
+		severity := "warning"
+		if diag.Severity == hcl.DiagError {
+			severity = "error"
+			failed++
+		}
+
+		if diag.Subject == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s; %s\n", severity, diag.Summary, diag.Detail)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", severity, diag.Error())
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("found %d errors in %d pipelines", failed, len(pipelines))
+	}
+
+	fmt.Printf("%d pipelines are valid\n", len(pipelines))
+	return nil
+}
+
+/*
+Draw how messages move through and between the pipelines named, or every
+pipeline if none are
+*/
+func cmdgraph(ctx *cli.Context) error {
 	literal, err := configure.ReadDirectory(ctx.String("chdir"))
 	if err != nil {
 		return err
 	}
 
+	descriptors, evalCtx, err := configure.Literal(path.Base(ctx.String("chdir")), literal)
+	if err != nil {
+		return err
+	}
+
+	names := ctx.Args().Slice()
+	if len(names) == 0 {
+		for name := range descriptors {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+	}
+
+	pipelines := make([]*configure.Pipeline, len(names))
+	for index, name := range names {
+		descriptor, ok := descriptors[name]
+		if !ok {
+			return fmt.Errorf("can't find pipeline %s", name)
+		}
+
+		pipelines[index] = descriptor
+	}
+
+	drawn := graph.Build(pipelines, evalCtx)
+	switch format := ctx.String("format"); format {
+	case "dot":
+		return drawn.WriteDOT(os.Stdout)
+	case "mermaid":
+		return drawn.WriteMermaid(os.Stdout)
+	case "json":
+		return printJSON(drawn)
+	default:
+		return fmt.Errorf("unknown format %s, expected dot, mermaid or json", format)
+	}
+}
+
+/*
+Every resource that pipelines of the workspace at --chdir could use, from the
+plugins it loads and the stdlib. A workspace without plugins doesn't need to
+be initialized
+*/
+func resources(ctx *cli.Context) ([]*catalog.Resource, error) {
+	literal, err := configure.ReadDirectory(ctx.String("chdir"))
+	if err != nil {
+		return nil, err
+	}
+
 	filename := path.Base(ctx.String("chdir"))
-	descriptors, evalCtx, err := configure.Literal(filename, literal)
+	descriptors, diags := configure.ParsePluginsDesc(filename, literal)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	plugins := make([]*sdk.Plugin, 0, len(descriptors)+1)
+	if len(descriptors) != 0 {
+		loaded, err := configure.LoadPlugins(path.Join(ctx.String("chdir"), ".psyduck"), filename, literal, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		plugins = append(plugins, loaded...)
+	}
+
+	return catalog.Describe(append(plugins, stdlib.Plugin())), nil
+}
+
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+/*
+List every resource that can be used, with the plugin it's from and its kinds
+*/
+func cmdresources(ctx *cli.Context) error {
+	found, err := resources(ctx)
 	if err != nil {
 		return err
 	}
 
-	initPath := path.Join(ctx.String("chdir"), ".psyduck")
-	plugins, err := configure.LoadPlugins(initPath, filename, literal, evalCtx)
+	switch {
+	case ctx.Bool("json"):
+		return printJSON(found)
+	case ctx.Bool("markdown"):
+		return catalog.WriteMarkdown(os.Stdout, found)
+	default:
+		return catalog.WriteList(os.Stdout, found)
+	}
+}
+
+/*
+Describe the options that the resources named take
+*/
+func describe(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return fmt.Errorf("expected the name of a resource to describe")
+	}
+
+	found, err := resources(ctx)
 	if err != nil {
 		return err
 	}
 
-	if !ctx.Args().Present() {
-		return fmt.Errorf("target required")
+	named := make([]*catalog.Resource, ctx.NArg())
+	for index, name := range ctx.Args().Slice() {
+		if named[index], err = catalog.Lookup(found, name); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case ctx.Bool("json"):
+		return printJSON(named)
+	case ctx.Bool("markdown"):
+		return catalog.WriteMarkdown(os.Stdout, named)
+	}
+
+	for index, resource := range named {
+		if index != 0 {
+			fmt.Println()
+		}
+
+		if err := catalog.WriteDescription(os.Stdout, resource); err != nil {
+			return err
+		}
 	}
 
-	target := ctx.Args().First()
-	descriptor, ok := descriptors[target]
-	if !ok {
-		return fmt.Errorf("can't find target %s", target)
+	return nil
+}
+
+func serve(ctx *cli.Context) error {
+	if ctx.Int("history") < 0 {
+		return fmt.Errorf("history can't be negative, got %d", ctx.Int("history"))
 	}
 
-	pipeline, err := core.BuildPipeline(descriptor, evalCtx, core.NewLibrary(plugins))
+	descriptors, evalCtx, library, err := workspace(ctx)
 	if err != nil {
 		return err
 	}
 
-	return core.RunPipeline(pipeline)
+	pipelines := make([]*configure.Pipeline, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		pipelines = append(pipelines, descriptor)
+	}
+
+	scheduler := core.NewScheduler(pipelines, evalCtx, library, ctx.Int("history"))
+	runCtx, stop := interruptible(ctx)
+	defer stop()
+
+	if addr := ctx.String("addr"); addr != "" {
+		defer listen(addr, "/runs", scheduler.HistoryHandler())()
+	}
+
+	return scheduler.Serve(runCtx)
 }
 
 func main() {
@@ -110,10 +611,99 @@ func main() {
 		Commands: []*cli.Command{
 			{
 				Name:      "run",
-				Usage:     "run a pipeline job",
+				Usage:     "run pipeline jobs, all at once",
 				Action:    run,
 				Args:      true,
-				ArgsUsage: "pipeline name",
+				ArgsUsage: "pipeline names...",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "run every pipeline",
+					},
+					&cli.BoolFlag{
+						Name:  "fail-fast",
+						Usage: "cancel the other pipelines once one fails",
+					},
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "run until interrupted, rebuilding pipelines when the .psy files they're read from change",
+					},
+					&cli.DurationFlag{
+						Name:  "drain-timeout",
+						Usage: "how long to wait for in-flight messages after being stopped, overriding the pipeline's drain-timeout",
+					},
+					&cli.StringFlag{
+						Name:  "metrics-addr",
+						Usage: "address to serve prometheus metrics on at /metrics, like :9090",
+					},
+				},
+			},
+			{
+				Name:   "serve",
+				Usage:  "run pipelines on their schedules until interrupted",
+				Action: serve,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Usage: "address to serve the history of recent runs on at /runs, like :9091",
+					},
+					&cli.IntFlag{
+						Name:  "history",
+						Usage: "how many recent runs to keep",
+						Value: 100,
+					},
+				},
+			},
+			{
+				Name:   "validate",
+				Usage:  "check every pipeline's resources against their specs without running anything",
+				Action: validate,
+			},
+			{
+				Name:      "graph",
+				Usage:     "draw how messages move through and between pipelines",
+				Action:    cmdgraph,
+				Args:      true,
+				ArgsUsage: "pipeline names...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "one of dot, mermaid or json",
+						Value: "dot",
+					},
+				},
+			},
+			{
+				Name:   "resources",
+				Usage:  "list the resources of the workspace's plugins and the stdlib",
+				Action: cmdresources,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print resources and their options as json",
+					},
+					&cli.BoolFlag{
+						Name:  "markdown",
+						Usage: "print documentation of resources and their options as markdown",
+					},
+				},
+			},
+			{
+				Name:      "describe",
+				Usage:     "describe the options that resources take",
+				Action:    describe,
+				Args:      true,
+				ArgsUsage: "resource names...",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the resources and their options as json",
+					},
+					&cli.BoolFlag{
+						Name:  "markdown",
+						Usage: "print documentation of the resources as markdown",
+					},
+				},
 			},
 			{
 				Name:   "init",
@@ -0,0 +1,13 @@
+package release
+
+/*
+Implemented by the config of a resource that takes hold of something when it's
+provided, such as a consumer counted as a writer of a queue, and only lets go of
+it once what was provided has run.
+
+When what was provided is never run, like when the rest of its pipeline fails to
+build, Release is called in its place so that what it holds is let go of
+*/
+type Releaser interface {
+	Release()
+}
@@ -0,0 +1,185 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+When a pipeline should be run, as parsed from a cron expression
+*/
+type Schedule struct {
+	expression string
+	minute     uint64
+	hour       uint64
+	dom        uint64
+	month      uint64
+	dow        uint64
+	anyDom     bool
+	anyDow     bool
+}
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+/*
+Parse one field of a cron expression into a set of the values it allows, as bits.
+Fields are comma separated lists of *, a value or a range of them, each
+optionally stepped by /n
+*/
+func parseField(field string, min, max int) (uint64, error) {
+	set := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if before, after, ok := strings.Cut(part, "/"); ok {
+			parsed, err := strconv.Atoi(after)
+			if err != nil || parsed < 1 {
+				return 0, fmt.Errorf("bad step %s", after)
+			}
+
+			span, step = before, parsed
+		}
+
+		low, high := min, max
+		switch {
+		case span == "*":
+		case strings.Contains(span, "-"):
+			before, after, _ := strings.Cut(span, "-")
+			var err error
+			if low, err = strconv.Atoi(before); err != nil {
+				return 0, fmt.Errorf("bad value %s", before)
+			}
+
+			if high, err = strconv.Atoi(after); err != nil {
+				return 0, fmt.Errorf("bad value %s", after)
+			}
+		default:
+			value, err := strconv.Atoi(span)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %s", span)
+			}
+
+			low, high = value, value
+			if step != 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%s is out of range %d-%d", span, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+
+	return set, nil
+}
+
+/*
+Parse a cron expression of five fields, being minute, hour, day of month, month
+and day of week, or one of the shorthands like @hourly. Days of week are 0-7,
+both 0 and 7 being sunday. Like cron, when both day fields are restricted a day
+matching either is allowed
+*/
+func Parse(expression string) (*Schedule, error) {
+	expanded := expression
+	if shorthand, ok := shorthands[expression]; ok {
+		expanded = shorthand
+	}
+
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields in %q, got %d", len(fields), expression, len(parts))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseField(parts[i], field.min, field.max)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing %s of %q: %s", field.name, expression, err)
+		}
+
+		sets[i] = set
+	}
+
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		expression: expression,
+		minute:     sets[0],
+		hour:       sets[1],
+		dom:        sets[2],
+		month:      sets[3],
+		dow:        sets[4],
+		anyDom:     strings.HasPrefix(parts[2], "*"),
+		anyDow:     strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func (schedule *Schedule) String() string {
+	return schedule.expression
+}
+
+func (schedule *Schedule) day(at time.Time) bool {
+	dom, dow := schedule.dom&(1<<at.Day()) != 0, schedule.dow&(1<<at.Weekday()) != 0
+	switch {
+	case schedule.anyDom && schedule.anyDow:
+		return true
+	case schedule.anyDom:
+		return dow
+	case schedule.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+/*
+The first time after after that the schedule allows, to the minute, in the
+location of after. The zero time is returned if there's none within 5 years, as
+with the 30th of february
+*/
+func (schedule *Schedule) Next(after time.Time) time.Time {
+	at := after.Truncate(time.Minute).Add(time.Minute)
+	limit := at.AddDate(5, 0, 0)
+	for at.Before(limit) {
+		switch {
+		case schedule.month&(1<<at.Month()) == 0:
+			at = time.Date(at.Year(), at.Month()+1, 1, 0, 0, 0, 0, at.Location())
+		case !schedule.day(at):
+			at = time.Date(at.Year(), at.Month(), at.Day()+1, 0, 0, 0, 0, at.Location())
+		case schedule.hour&(1<<at.Hour()) == 0:
+			at = time.Date(at.Year(), at.Month(), at.Day(), at.Hour()+1, 0, 0, 0, at.Location())
+		case schedule.minute&(1<<at.Minute()) == 0:
+			at = at.Add(time.Minute)
+		default:
+			return at
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,54 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(test *testing.T) {
+	from := time.Date(2024, time.March, 14, 10, 7, 30, 0, time.UTC) // a thursday
+	cases := []struct {
+		Expression string
+		Want       time.Time
+	}{
+		{"*/5 * * * *", time.Date(2024, time.March, 14, 10, 10, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2024, time.March, 14, 10, 8, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2024, time.March, 15, 9, 0, 0, 0, time.UTC)},
+		{"30 8-18/2 * * *", time.Date(2024, time.March, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 5", time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"15,45 * * 4 *", time.Date(2024, time.April, 1, 0, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, testcase := range cases {
+		schedule, err := Parse(testcase.Expression)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		assert.Equal(test, testcase.Want, schedule.Next(from), testcase.Expression)
+	}
+}
+
+func TestParse_invalid(test *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@sometimes",
+	} {
+		_, err := Parse(expression)
+		assert.NotNil(test, err, expression)
+	}
+}
@@ -0,0 +1,83 @@
+package channel
+
+import "sync"
+
+/*
+A named in-process queue of messages, written to by any number of writers and
+read from until the last of them is done
+*/
+type Queue struct {
+	name    string
+	data    chan []byte
+	writers int
+	closed  bool
+}
+
+// guards queues along with the writers and closed of each queue
+var (
+	queuesLock sync.Mutex
+	queues     = make(map[string]*Queue)
+)
+
+func named(name string, size int) *Queue {
+	if queue, ok := queues[name]; ok {
+		return queue
+	}
+
+	queue := &Queue{name: name, data: make(chan []byte, size)}
+	queues[name] = queue
+	return queue
+}
+
+/*
+The open queue called name, holding up to size messages before writers block.
+It's created if there isn't one, in which case size is used; otherwise size is
+ignored
+*/
+func Named(name string, size int) *Queue {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+	return named(name, size)
+}
+
+/*
+The open queue called name, like Named, counted as having one more writer, which
+it won't be closed without. Finding the queue and counting the writer happen at
+once, so that the queue can't be closed in between. Writers should be attached
+before any of them might be done, so that the queue isn't closed early
+*/
+func Attach(name string, size int) *Queue {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+	queue := named(name, size)
+	queue.writers++
+	return queue
+}
+
+/*
+Mark a writer as done. Once every writer is, the queue is closed, ending the
+stream for its readers, and the name is free for a new queue
+*/
+func (queue *Queue) Detach() {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	queue.writers--
+	if queue.writers > 0 || queue.closed {
+		return
+	}
+
+	queue.closed = true
+	close(queue.data)
+	if queues[queue.name] == queue {
+		delete(queues, queue.name)
+	}
+}
+
+func (queue *Queue) Send(data []byte) {
+	queue.data <- data
+}
+
+func (queue *Queue) Recv() <-chan []byte {
+	return queue.data
+}
@@ -0,0 +1,52 @@
+package channel
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(test *testing.T) {
+	queue := Attach("test-queue", 4)
+	assert.Same(test, queue, Attach("test-queue", 0))
+	assert.Same(test, queue, Named("test-queue", 0))
+
+	queue.Send([]byte("huge"))
+	queue.Detach()
+	queue.Send([]byte("pixie"))
+	queue.Detach()
+
+	received := []string{}
+	for data := range queue.Recv() {
+		received = append(received, string(data))
+	}
+
+	assert.Equal(test, []string{"huge", "pixie"}, received)
+	reopened := Named("test-queue", 0)
+	assert.NotSame(test, queue, reopened, "a closed queue's name is free")
+
+	assert.Same(test, reopened, Attach("test-queue", 0))
+	reopened.Detach()
+}
+
+func TestAttach_concurrent(test *testing.T) {
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue := Attach("test-concurrent", 1)
+			go func() {
+				for range queue.Recv() {
+				}
+			}()
+
+			queue.Send([]byte("cat"))
+			queue.Detach()
+		}()
+	}
+
+	wg.Wait()
+	assert.NotContains(test, queues, "test-concurrent", "every queue was closed")
+}
@@ -0,0 +1,44 @@
+package consume
+
+import (
+	"github.com/gastrodon/psyduck/stdlib/channel"
+	"github.com/psyduck-etl/sdk"
+)
+
+type channelConfig struct {
+	Name string `psy:"name"`
+	Size int    `psy:"size"`
+
+	queue *channel.Queue
+}
+
+/*
+Stop writing to the queue without having run, as the consumer won't be
+*/
+func (config *channelConfig) Release() {
+	config.queue.Detach()
+}
+
+/*
+Channel consumes into the in-process queue config.Name, blocking while it's full.
+The queue is written to from when the consumer is provided, so its readers see
+the end of the stream once every consumer provided for it is done, or released
+if it's never run
+*/
+func Channel(parse sdk.Parser) (sdk.Consumer, error) {
+	config := new(channelConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	config.queue = channel.Attach(config.Name, config.Size)
+	return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
+		defer close(done)
+		defer close(errs)
+		defer config.queue.Detach()
+
+		for data := range recv {
+			config.queue.Send(data)
+		}
+	}, nil
+}
@@ -36,6 +36,26 @@ func Plugin() *sdk.Plugin {
 				Kinds:           sdk.CONSUMER,
 				ProvideConsumer: consume.Trash,
 			},
+			{
+				Name:            "channel",
+				Kinds:           sdk.PRODUCER | sdk.CONSUMER,
+				ProvideProducer: produce.Channel,
+				ProvideConsumer: consume.Channel,
+				Spec: sdk.SpecMap{
+					"name": &sdk.Spec{
+						Name:        "name",
+						Description: "name of the in-process queue, shared by pipelines run together",
+						Type:        cty.String,
+						Required:    true,
+					},
+					"size": &sdk.Spec{
+						Name:        "size",
+						Description: "messages the queue holds before consumers block",
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+				},
+			},
 			{
 				Name:               "inspect",
 				Kinds:              sdk.TRANSFORMER,
@@ -121,6 +141,99 @@ func Plugin() *sdk.Plugin {
 					},
 				},
 			},
+			{
+				Name:               "explode",
+				Kinds:              sdk.TRANSFORMER,
+				ProvideTransformer: transform.Explode,
+				Spec: sdk.SpecMap{
+					"field": &sdk.Spec{
+						Name:        "field",
+						Description: "field holding the array to explode, or the whole message if unset",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+				},
+			},
+			{
+				Name:               "window",
+				Kinds:              sdk.TRANSFORMER,
+				ProvideTransformer: transform.Window,
+				Spec: sdk.SpecMap{
+					"key": &sdk.Spec{
+						Name:        "key",
+						Description: "dotted path of the field to group messages by, or everything in one group if unset",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					"size": &sdk.Spec{
+						Name:        "size",
+						Description: "duration of each time window",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					"slide": &sdk.Spec{
+						Name:        "slide",
+						Description: "duration between the starts of time windows, the same as size unless they should overlap",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					"count": &sdk.Spec{
+						Name:        "count",
+						Description: "number of messages in each window, in place of a size",
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					"fields": &sdk.Spec{
+						Name:        "fields",
+						Description: "dotted paths of the numeric fields to aggregate",
+						Type:        cty.List(cty.String),
+						Default:     cty.ListValEmpty(cty.String),
+					},
+					"aggregates": &sdk.Spec{
+						Name:        "aggregates",
+						Description: "aggregates to compute over each field, of count, sum, min, max and avg",
+						Type:        cty.List(cty.String),
+						Default:     cty.ListVal([]cty.Value{cty.StringVal("count"), cty.StringVal("sum"), cty.StringVal("min"), cty.StringVal("max"), cty.StringVal("avg")}),
+					},
+					"time-field": &sdk.Spec{
+						Name:        "time-field",
+						Description: "dotted path of the field holding when each message happened, or when it's seen if unset",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+				},
+			},
+			{
+				Name:               "dedupe",
+				Kinds:              sdk.TRANSFORMER,
+				ProvideTransformer: transform.Dedupe,
+				Spec: sdk.SpecMap{
+					"field": &sdk.Spec{
+						Name:        "field",
+						Description: "dotted path of the field to deduplicate by, or a hash of the whole message if unset",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					"max-size": &sdk.Spec{
+						Name:        "max-size",
+						Description: "most keys to remember, forgetting the least recently seen",
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(10000),
+					},
+					"ttl": &sdk.Spec{
+						Name:        "ttl",
+						Description: "how long a key is remembered after it's last seen, or forever if unset",
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					"persist": &sdk.Spec{
+						Name:        "persist",
+						Description: "keep seen keys between runs, in the pipeline's .psyduck/state directory",
+						Type:        cty.Bool,
+						Default:     cty.False,
+					},
+				},
+			},
 			{
 				Name:  "increment",
 				Kinds: sdk.PRODUCER,
@@ -134,6 +247,19 @@ func Plugin() *sdk.Plugin {
 				},
 				ProvideProducer: produce.Increment,
 			},
+			{
+				Name:  "file",
+				Kinds: sdk.PRODUCER,
+				Spec: sdk.SpecMap{
+					"path": &sdk.Spec{
+						Name:        "path",
+						Description: "file to produce each line of",
+						Type:        cty.String,
+						Required:    true,
+					},
+				},
+				ProvideProducer: produce.File,
+			},
 		},
 	}
 }
@@ -0,0 +1,32 @@
+package produce
+
+import (
+	"github.com/gastrodon/psyduck/stdlib/channel"
+	"github.com/psyduck-etl/sdk"
+)
+
+type channelConfig struct {
+	Name string `psy:"name"`
+	Size int    `psy:"size"`
+}
+
+/*
+Channel produces what's consumed into the in-process queue config.Name, until
+every consumer writing to it is done
+*/
+func Channel(parse sdk.Parser) (sdk.Producer, error) {
+	config := new(channelConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	queue := channel.Named(config.Name, config.Size)
+	return func(send chan<- []byte, errs chan<- error) {
+		defer close(send)
+		defer close(errs)
+
+		for data := range queue.Recv() {
+			send <- data
+		}
+	}, nil
+}
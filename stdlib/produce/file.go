@@ -0,0 +1,84 @@
+package produce
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/psyduck-etl/sdk"
+)
+
+type file struct {
+	Path   string `psy:"path"`
+	offset int64
+	mark   func([]byte)
+}
+
+/*
+The position of a file is the byte offset just past the last line sent
+*/
+func (config *file) Resume(position []byte, mark func([]byte)) error {
+	config.mark = mark
+	if position == nil {
+		return nil
+	}
+
+	offset, err := strconv.ParseInt(string(position), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	config.offset = offset
+	return nil
+}
+
+/*
+Produce each line of a file, without its trailing newline
+*/
+func File(parse sdk.Parser) (sdk.Producer, error) {
+	config := new(file)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	return func(send chan<- []byte, errs chan<- error) {
+		defer close(send)
+		defer close(errs)
+
+		handle, err := os.Open(config.Path)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		defer handle.Close()
+		if _, err := handle.Seek(config.offset, io.SeekStart); err != nil {
+			errs <- err
+			return
+		}
+
+		reader, offset := bufio.NewReader(handle), config.offset
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) != 0 {
+				offset += int64(len(line))
+				if config.mark != nil {
+					config.mark([]byte(strconv.FormatInt(offset, 10)))
+				}
+
+				send <- bytes.TrimSuffix(line, []byte{'\n'})
+			}
+
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}, nil
+}
@@ -1,12 +1,37 @@
 package produce
 
-import "github.com/psyduck-etl/sdk"
+import (
+	"strconv"
 
-func Increment(parse sdk.Parser) (sdk.Producer, error) {
-	config := new(struct {
-		StopAfter byte `psy:"stop-after"`
-	})
+	"github.com/psyduck-etl/sdk"
+)
+
+type increment struct {
+	StopAfter byte `psy:"stop-after"`
+	start     int
+	mark      func([]byte)
+}
 
+/*
+The position of an increment is how many values it's sent
+*/
+func (config *increment) Resume(position []byte, mark func([]byte)) error {
+	config.mark = mark
+	if position == nil {
+		return nil
+	}
+
+	start, err := strconv.Atoi(string(position))
+	if err != nil {
+		return err
+	}
+
+	config.start = start
+	return nil
+}
+
+func Increment(parse sdk.Parser) (sdk.Producer, error) {
+	config := new(increment)
 	if err := parse(config); err != nil {
 		return nil, err
 	}
@@ -14,8 +39,12 @@ func Increment(parse sdk.Parser) (sdk.Producer, error) {
 	return func(send chan<- []byte, errs chan<- error) {
 		defer close(send)
 		defer close(errs)
-		for i := byte(0); config.StopAfter == 0 || i < config.StopAfter; i++ {
-			send <- []byte{i}
+		for i := config.start; config.StopAfter == 0 || i < int(config.StopAfter); i++ {
+			if config.mark != nil {
+				config.mark([]byte(strconv.Itoa(i + 1)))
+			}
+
+			send <- []byte{byte(i)}
 		}
 	}, nil
 }
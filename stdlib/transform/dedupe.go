@@ -0,0 +1,232 @@
+package transform
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gastrodon/psyduck/checkpoint"
+	"github.com/psyduck-etl/sdk"
+)
+
+type dedupeConfig struct {
+	Field   string `psy:"field"`
+	MaxSize int    `psy:"max-size"`
+	TTL     string `psy:"ttl"`
+	Persist bool   `psy:"persist"`
+
+	ttl   time.Duration
+	store *checkpoint.Store
+	stage string
+	lock  sync.Mutex
+	seen  map[string]*list.Element
+	order *list.List // of *seenKey, most recently seen first
+	dirty bool
+}
+
+type seenKey struct {
+	Key  string    `json:"key"`
+	Seen time.Time `json:"seen"`
+}
+
+/*
+The key that data is deduplicated by, being config.Field or a hash of all of it
+*/
+func (config *dedupeConfig) keyOf(data []byte) (string, error) {
+	if config.Field == "" {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	key, err := lookupPath(data, config.Field)
+	if err != nil {
+		return "", err
+	}
+
+	if key == nil {
+		return "", fmt.Errorf("no key at %s", config.Field)
+	}
+
+	return string(key), nil
+}
+
+func (config *dedupeConfig) expired(each *seenKey, now time.Time) bool {
+	return config.ttl != 0 && now.Sub(each.Seen) >= config.ttl
+}
+
+/*
+Forget keys that have expired, and the least recently seen ones past
+config.MaxSize
+*/
+func (config *dedupeConfig) prune(now time.Time) {
+	for back := config.order.Back(); back != nil; back = config.order.Back() {
+		each := back.Value.(*seenKey)
+		if len(config.seen) <= config.MaxSize && !config.expired(each, now) {
+			return
+		}
+
+		config.order.Remove(back)
+		delete(config.seen, each.Key)
+		config.dirty = true
+	}
+}
+
+/*
+Whether key was seen before now, marking it as seen at now either way
+*/
+func (config *dedupeConfig) repeated(key string, now time.Time) bool {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+
+	config.dirty = true
+	if found, ok := config.seen[key]; ok {
+		each := found.Value.(*seenKey)
+		if !config.expired(each, now) {
+			each.Seen = now
+			config.order.MoveToFront(found)
+			return true
+		}
+
+		config.order.Remove(found)
+	}
+
+	config.seen[key] = config.order.PushFront(&seenKey{Key: key, Seen: now})
+	config.prune(now)
+	return false
+}
+
+/*
+Give nothing for a message whose key was already seen
+*/
+func (config *dedupeConfig) FlatMap(data []byte) ([][]byte, error) {
+	key, err := config.keyOf(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.repeated(key, time.Now()) {
+		return nil, nil
+	}
+
+	return [][]byte{data}, nil
+}
+
+/*
+Never, as the messages a dedupe drops aren't held back to be given later
+*/
+func (config *dedupeConfig) HeldSince() time.Time {
+	return time.Time{}
+}
+
+/*
+Forget expired keys and save what's left, if config.Persist is set. Nothing's
+ever given, as nothing's held back
+*/
+func (config *dedupeConfig) Flush(now time.Time, final bool) ([][]byte, error) {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+
+	config.prune(now)
+	if config.store == nil || !config.dirty {
+		return nil, nil
+	}
+
+	saved := make([]*seenKey, 0, len(config.seen))
+	for each := config.order.Back(); each != nil; each = each.Prev() {
+		saved = append(saved, each.Value.(*seenKey))
+	}
+
+	encoded, err := json.Marshal(saved)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.store.Save(config.stage, encoded); err != nil {
+		return nil, fmt.Errorf("failed saving seen keys: %s", err)
+	}
+
+	config.dirty = false
+	return nil, nil
+}
+
+func (config *dedupeConfig) init() error {
+	config.seen, config.order = make(map[string]*list.Element), list.New()
+	if config.MaxSize < 1 {
+		return fmt.Errorf("max-size must be at least 1, got %d", config.MaxSize)
+	}
+
+	if config.TTL != "" {
+		ttl, err := time.ParseDuration(config.TTL)
+		if err != nil {
+			return fmt.Errorf("can't parse ttl: %s", err)
+		}
+
+		if ttl <= 0 {
+			return fmt.Errorf("ttl must be positive, got %s", ttl)
+		}
+
+		config.ttl = ttl
+	}
+
+	if config.store == nil {
+		return nil
+	}
+
+	encoded, err := config.store.Load(config.stage)
+	if err != nil || encoded == nil {
+		return err
+	}
+
+	saved := make([]*seenKey, 0)
+	if err := json.Unmarshal(encoded, &saved); err != nil {
+		return fmt.Errorf("can't read seen keys of %s: %s", config.stage, err)
+	}
+
+	for _, each := range saved {
+		config.seen[each.Key] = config.order.PushFront(each)
+	}
+
+	config.prune(time.Now())
+	return nil
+}
+
+/*
+Keep seen keys in store as stage, if config.Persist is set. Those seen by a
+previous run are loaded once the config's checked
+*/
+func (config *dedupeConfig) Restore(store *checkpoint.Store, stage string) error {
+	if config.Persist {
+		config.store, config.stage = store, stage
+	}
+
+	return nil
+}
+
+/*
+Dedupe drops messages whose key was seen within config.TTL, remembering at most
+config.MaxSize of the most recently seen keys. As a plain sdk.Transformer, its
+seen keys are never saved, even with config.Persist
+*/
+func Dedupe(parse sdk.Parser) (sdk.Transformer, error) {
+	config := new(dedupeConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	if err := config.init(); err != nil {
+		return nil, err
+	}
+
+	return func(data []byte) ([]byte, error) {
+		deduped, err := config.FlatMap(data)
+		if err != nil || len(deduped) == 0 {
+			return nil, err
+		}
+
+		return deduped[0], nil
+	}, nil
+}
@@ -0,0 +1,102 @@
+package transform
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gastrodon/psyduck/checkpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+func dedupeOf(test *testing.T, config *dedupeConfig) *dedupeConfig {
+	if err := config.init(); err != nil {
+		test.Fatal(err)
+	}
+
+	return config
+}
+
+func TestDedupe(test *testing.T) {
+	config := dedupeOf(test, &dedupeConfig{Field: "id", MaxSize: 2})
+
+	kept := make([]string, 0)
+	for _, message := range []string{
+		`{"id": 1, "cat": "huge"}`,
+		`{"id": 1, "cat": "huge, again"}`,
+		`{"id": 2, "cat": "pixie"}`,
+		`{"id": 3, "cat": "edward"}`,
+		`{"id": 2, "cat": "pixie, again"}`,
+		`{"id": 1, "cat": "huge, forgotten"}`,
+	} {
+		out, err := config.FlatMap([]byte(message))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		for _, each := range out {
+			kept = append(kept, string(each))
+		}
+	}
+
+	assert.Equal(test, []string{
+		`{"id": 1, "cat": "huge"}`,
+		`{"id": 2, "cat": "pixie"}`,
+		`{"id": 3, "cat": "edward"}`,
+		`{"id": 1, "cat": "huge, forgotten"}`,
+	}, kept)
+
+	_, err := config.FlatMap([]byte(`{"cat": "alice"}`))
+	assert.NotNil(test, err)
+}
+
+func TestDedupe_ttl(test *testing.T) {
+	config := dedupeOf(test, &dedupeConfig{MaxSize: 10, TTL: "1m"})
+	now := time.Now()
+
+	assert.False(test, config.repeated("huge", now))
+	assert.True(test, config.repeated("huge", now.Add(30*time.Second)))
+	assert.True(test, config.repeated("huge", now.Add(80*time.Second)), "seeing a key again keeps it around")
+	assert.False(test, config.repeated("huge", now.Add(3*time.Minute)))
+
+	config.Flush(now.Add(5*time.Minute), false)
+	assert.Empty(test, config.seen)
+}
+
+func TestDedupe_state(test *testing.T) {
+	store := checkpoint.NewStore(filepath.Join(test.TempDir(), "state", "test"))
+	restored := func() *dedupeConfig {
+		config := &dedupeConfig{MaxSize: 10, Persist: true}
+		if err := config.Restore(store, "transform.dedupe.d"); err != nil {
+			test.Fatal(err)
+		}
+
+		return dedupeOf(test, config)
+	}
+
+	config := restored()
+	for _, message := range []string{"huge", "pixie", "huge"} {
+		config.FlatMap([]byte(message))
+	}
+
+	if _, err := config.Flush(time.Now(), true); err != nil {
+		test.Fatal(err)
+	}
+
+	resumed := restored()
+	for message, want := range map[string]int{"huge": 0, "pixie": 0, "edward": 1} {
+		out, err := resumed.FlatMap([]byte(message))
+		assert.Nil(test, err)
+		assert.Len(test, out, want, message)
+	}
+}
+
+func TestDedupe_config(test *testing.T) {
+	for _, config := range []*dedupeConfig{
+		{},
+		{MaxSize: 10, TTL: "later"},
+		{MaxSize: 10, TTL: "-1m"},
+	} {
+		assert.NotNil(test, config.init())
+	}
+}
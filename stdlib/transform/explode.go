@@ -0,0 +1,66 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/psyduck-etl/sdk"
+)
+
+type explodeConfig struct {
+	Field string `psy:"field"`
+}
+
+/*
+Split the json array at config.Field ( or the whole message, if there's no field )
+into a message for each of its items
+*/
+func (config *explodeConfig) FlatMap(data []byte) ([][]byte, error) {
+	var array []json.RawMessage
+	if config.Field == "" {
+		if err := json.Unmarshal(data, &array); err != nil {
+			return nil, err
+		}
+	} else {
+		source := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(data, &source); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(source[config.Field], &array); err != nil {
+			return nil, fmt.Errorf("field %s isn't an array: %s", config.Field, err)
+		}
+	}
+
+	exploded := make([][]byte, len(array))
+	for i, item := range array {
+		exploded[i] = item
+	}
+
+	return exploded, nil
+}
+
+/*
+Explode gives a message per item, which a plain sdk.Transformer can only do when
+there's at most one of them
+*/
+func Explode(parse sdk.Parser) (sdk.Transformer, error) {
+	config := new(explodeConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	return func(data []byte) ([]byte, error) {
+		exploded, err := config.FlatMap(data)
+		switch {
+		case err != nil:
+			return nil, err
+		case len(exploded) == 0:
+			return nil, nil
+		case len(exploded) == 1:
+			return exploded[0], nil
+		default:
+			return nil, fmt.Errorf("exploded into %d messages, but only one can be returned here", len(exploded))
+		}
+	}, nil
+}
@@ -0,0 +1,38 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplode(test *testing.T) {
+	cases := []struct {
+		Field  string
+		Source string
+		Want   []string
+	}{
+		{"", `[1, "two", {"three": 3}]`, []string{`1`, `"two"`, `{"three": 3}`}},
+		{"cats", `{"cats": ["huge", "pixie"], "dogs": []}`, []string{`"huge"`, `"pixie"`}},
+		{"dogs", `{"cats": ["huge", "pixie"], "dogs": []}`, []string{}},
+	}
+
+	for index, testcase := range cases {
+		config := &explodeConfig{Field: testcase.Field}
+		exploded, err := config.FlatMap([]byte(testcase.Source))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		have := make([]string, len(exploded))
+		for i, each := range exploded {
+			have[i] = string(each)
+		}
+
+		assert.Equal(test, testcase.Want, have, "explode #%d", index)
+	}
+
+	if _, err := (&explodeConfig{Field: "cats"}).FlatMap([]byte(`{"cats": "huge"}`)); err == nil {
+		test.Fatal("expected an error exploding a string")
+	}
+}
@@ -0,0 +1,416 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+)
+
+var windowAggregates = map[string]bool{"count": true, "sum": true, "min": true, "max": true, "avg": true}
+
+type windowConfig struct {
+	Key        string   `psy:"key"`
+	Size       string   `psy:"size"`
+	Slide      string   `psy:"slide"`
+	Count      int      `psy:"count"`
+	Fields     []string `psy:"fields"`
+	Aggregates []string `psy:"aggregates"`
+	TimeField  string   `psy:"time-field"`
+
+	size, slide time.Duration
+	aggregates  map[string]bool
+	lock        sync.Mutex
+	open        map[windowKey]*window
+	watermark   time.Time
+}
+
+type windowKey struct {
+	key   string
+	start int64
+}
+
+/*
+What's been seen of some field in a window
+*/
+type fieldAggregate struct {
+	count         int
+	sum, min, max float64
+}
+
+/*
+The messages of one key that fall between start and end, aggregated. Count
+windows have no end until they're full. Since is when the window was opened,
+going by the clock rather than the times of its messages
+*/
+type window struct {
+	key         json.RawMessage
+	start, end  time.Time
+	first, last time.Time
+	since       time.Time
+	count       int
+	fields      map[string]*fieldAggregate
+}
+
+/*
+Look up a field of data by its path, split on dots. Missing fields are nil
+*/
+func lookupPath(data []byte, path string) (json.RawMessage, error) {
+	value := json.RawMessage(data)
+	for _, part := range strings.Split(path, ".") {
+		object := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, fmt.Errorf("can't look up %s in a non-object", path)
+		}
+
+		if value = object[part]; value == nil {
+			return nil, nil
+		}
+	}
+
+	return value, nil
+}
+
+/*
+The time a message happened at, being now or what's held in config.TimeField,
+either as an RFC3339 string or as seconds since the epoch
+*/
+func (config *windowConfig) timeOf(data []byte, now time.Time) (time.Time, error) {
+	if config.TimeField == "" {
+		return now, nil
+	}
+
+	raw, err := lookupPath(data, config.TimeField)
+	if err != nil || raw == nil {
+		return time.Time{}, fmt.Errorf("no time at %s", config.TimeField)
+	}
+
+	var stamp interface{}
+	if err := json.Unmarshal(raw, &stamp); err != nil {
+		return time.Time{}, err
+	}
+
+	switch stamp := stamp.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, stamp)
+	case float64:
+		seconds, fraction := math.Modf(stamp)
+		return time.Unix(int64(seconds), int64(fraction*float64(time.Second))), nil
+	default:
+		return time.Time{}, fmt.Errorf("time at %s isn't a string or number", config.TimeField)
+	}
+}
+
+/*
+The starts of every time window that at falls into, latest first
+*/
+func (config *windowConfig) starts(at time.Time) []time.Time {
+	latest := at.Truncate(config.slide)
+	starts := make([]time.Time, 0, config.size/config.slide+1)
+	for start := latest; at.Before(start.Add(config.size)); start = start.Add(-config.slide) {
+		starts = append(starts, start)
+	}
+
+	return starts
+}
+
+func (w *window) add(data []byte, at time.Time, fields []string) {
+	if w.count == 0 || at.Before(w.first) {
+		w.first = at
+	}
+
+	if at.After(w.last) {
+		w.last = at
+	}
+
+	w.count++
+	for _, field := range fields {
+		raw, err := lookupPath(data, field)
+		if err != nil || raw == nil {
+			continue
+		}
+
+		var value float64
+		if err := json.Unmarshal(raw, &value); err != nil {
+			continue
+		}
+
+		seen, ok := w.fields[field]
+		if !ok {
+			seen = &fieldAggregate{min: value, max: value}
+			w.fields[field] = seen
+		}
+
+		seen.count++
+		seen.sum += value
+		seen.min = math.Min(seen.min, value)
+		seen.max = math.Max(seen.max, value)
+	}
+}
+
+/*
+A window as the message that it closes into
+*/
+func (config *windowConfig) encode(w *window) ([]byte, error) {
+	start, end := w.start, w.end
+	if config.Count != 0 {
+		start, end = w.first, w.last
+	}
+
+	fields := make(map[string]map[string]interface{}, len(config.Fields))
+	for _, field := range config.Fields {
+		aggregated := make(map[string]interface{})
+		seen, ok := w.fields[field]
+		if !ok {
+			seen = new(fieldAggregate)
+		}
+
+		if config.aggregates["count"] {
+			aggregated["count"] = seen.count
+		}
+
+		if config.aggregates["sum"] {
+			aggregated["sum"] = seen.sum
+		}
+
+		for name, value := range map[string]float64{"min": seen.min, "max": seen.max, "avg": seen.sum / float64(seen.count)} {
+			if !config.aggregates[name] {
+				continue
+			}
+
+			if seen.count == 0 {
+				aggregated[name] = nil
+			} else {
+				aggregated[name] = value
+			}
+		}
+
+		fields[field] = aggregated
+	}
+
+	key := w.key
+	if key == nil {
+		key = json.RawMessage("null")
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"key":    key,
+		"start":  start.Format(time.RFC3339Nano),
+		"end":    end.Format(time.RFC3339Nano),
+		"count":  w.count,
+		"fields": fields,
+	})
+}
+
+/*
+Close the windows that pick chooses, in the order that they ended
+*/
+func (config *windowConfig) close(pick func(*window) bool) ([][]byte, error) {
+	closing := make([]windowKey, 0)
+	for key, w := range config.open {
+		if pick(w) {
+			closing = append(closing, key)
+		}
+	}
+
+	sort.Slice(closing, func(i, j int) bool {
+		left, right := config.open[closing[i]], config.open[closing[j]]
+		if !left.end.Equal(right.end) {
+			return left.end.Before(right.end)
+		}
+
+		if closing[i].start != closing[j].start {
+			return closing[i].start < closing[j].start
+		}
+
+		return closing[i].key < closing[j].key
+	})
+
+	closed := make([][]byte, len(closing))
+	for i, key := range closing {
+		encoded, err := config.encode(config.open[key])
+		if err != nil {
+			return nil, err
+		}
+
+		closed[i] = encoded
+		delete(config.open, key)
+	}
+
+	return closed, nil
+}
+
+/*
+Add a message to the windows it falls into, giving any that it closes. A time
+window closes once a message from after its end is seen, and a count window
+once it's full. Messages too late for any open window are dropped
+*/
+func (config *windowConfig) FlatMap(data []byte) ([][]byte, error) {
+	now := time.Now()
+	at, err := config.timeOf(data, now)
+	if err != nil {
+		return nil, err
+	}
+
+	key := json.RawMessage(nil)
+	if config.Key != "" {
+		if key, err = lookupPath(data, config.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	config.lock.Lock()
+	defer config.lock.Unlock()
+
+	if config.Count != 0 {
+		id := windowKey{key: string(key)}
+		w, ok := config.open[id]
+		if !ok {
+			w = &window{key: key, since: now, fields: make(map[string]*fieldAggregate)}
+			config.open[id] = w
+		}
+
+		w.add(data, at, config.Fields)
+		if w.count < config.Count {
+			return nil, nil
+		}
+
+		return config.close(func(each *window) bool { return each == w })
+	}
+
+	if at.After(config.watermark) {
+		config.watermark = at
+	}
+
+	for _, start := range config.starts(at) {
+		end := start.Add(config.size)
+		if !config.watermark.Before(end) {
+			continue
+		}
+
+		id := windowKey{key: string(key), start: start.UnixNano()}
+		w, ok := config.open[id]
+		if !ok {
+			w = &window{key: key, start: start, end: end, since: now, fields: make(map[string]*fieldAggregate)}
+			config.open[id] = w
+		}
+
+		w.add(data, at, config.Fields)
+	}
+
+	return config.close(func(each *window) bool { return !config.watermark.Before(each.end) })
+}
+
+/*
+Close the time windows that have ended by now, or every window if final. When
+windows are timed by config.TimeField, they're only closed by the messages that
+come after them, or by the final flush
+*/
+func (config *windowConfig) Flush(now time.Time, final bool) ([][]byte, error) {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+
+	if final {
+		return config.close(func(*window) bool { return true })
+	}
+
+	if config.Count != 0 || config.TimeField != "" {
+		return nil, nil
+	}
+
+	return config.close(func(each *window) bool { return !now.Before(each.end) })
+}
+
+/*
+When the oldest window still open was opened, or the zero time if none are
+*/
+func (config *windowConfig) HeldSince() time.Time {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+
+	var since time.Time
+	for _, w := range config.open {
+		if since.IsZero() || w.since.Before(since) {
+			since = w.since
+		}
+	}
+
+	return since
+}
+
+/*
+Window aggregates messages by config.Key into tumbling or sliding windows of time
+or into windows of a count of messages, and gives a message for each window once
+it closes. As a plain sdk.Transformer it can only give the windows that close as
+messages come in, and never flushes what's left at the end
+*/
+func Window(parse sdk.Parser) (sdk.Transformer, error) {
+	config := new(windowConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	if err := config.init(); err != nil {
+		return nil, err
+	}
+
+	return func(data []byte) ([]byte, error) {
+		closed, err := config.FlatMap(data)
+		switch {
+		case err != nil:
+			return nil, err
+		case len(closed) == 0:
+			return nil, nil
+		case len(closed) == 1:
+			return closed[0], nil
+		default:
+			return nil, fmt.Errorf("closed %d windows, but only one can be returned here", len(closed))
+		}
+	}, nil
+}
+
+func (config *windowConfig) init() error {
+	config.open = make(map[windowKey]*window)
+	config.aggregates = make(map[string]bool, len(config.Aggregates))
+	for _, aggregate := range config.Aggregates {
+		if !windowAggregates[aggregate] {
+			return fmt.Errorf("unknown aggregate %s", aggregate)
+		}
+
+		config.aggregates[aggregate] = true
+	}
+
+	switch {
+	case config.Count < 0:
+		return fmt.Errorf("count must be positive, got %d", config.Count)
+	case config.Count != 0 && (config.Size != "" || config.Slide != ""):
+		return fmt.Errorf("count windows can't have a size or slide")
+	case config.Count != 0:
+		return nil
+	case config.Size == "":
+		return fmt.Errorf("one of size or count is required")
+	}
+
+	var err error
+	if config.size, err = time.ParseDuration(config.Size); err != nil {
+		return fmt.Errorf("can't parse size: %s", err)
+	}
+
+	config.slide = config.size
+	if config.Slide != "" {
+		if config.slide, err = time.ParseDuration(config.Slide); err != nil {
+			return fmt.Errorf("can't parse slide: %s", err)
+		}
+	}
+
+	if config.size <= 0 || config.slide <= 0 || config.slide > config.size {
+		return fmt.Errorf("size and slide must be positive, with slide no more than size")
+	}
+
+	return nil
+}
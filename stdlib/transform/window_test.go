@@ -0,0 +1,145 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type windowed struct {
+	Key    interface{}                       `json:"key"`
+	Start  string                            `json:"start"`
+	End    string                            `json:"end"`
+	Count  int                               `json:"count"`
+	Fields map[string]map[string]interface{} `json:"fields"`
+}
+
+func windowOf(test *testing.T, config *windowConfig) *windowConfig {
+	if config.Aggregates == nil {
+		config.Aggregates = []string{"count", "sum", "min", "max", "avg"}
+	}
+
+	if err := config.init(); err != nil {
+		test.Fatal(err)
+	}
+
+	return config
+}
+
+func decodeWindows(test *testing.T, closed [][]byte) []windowed {
+	decoded := make([]windowed, len(closed))
+	for i, each := range closed {
+		if err := json.Unmarshal(each, &decoded[i]); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	return decoded
+}
+
+func TestWindow_tumbling(test *testing.T) {
+	config := windowOf(test, &windowConfig{Key: "cat", Size: "1m", Fields: []string{"weight"}, TimeField: "at"})
+
+	closed := make([][]byte, 0)
+	for _, message := range []string{
+		`{"cat": "huge", "weight": 6, "at": 0}`,
+		`{"cat": "pixie", "weight": 3, "at": 10}`,
+		`{"cat": "huge", "weight": 8, "at": 59}`,
+		`{"cat": "huge", "weight": 7, "at": 61}`,
+	} {
+		out, err := config.FlatMap([]byte(message))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		closed = append(closed, out...)
+	}
+
+	windows := decodeWindows(test, closed)
+	assert.Len(test, windows, 2)
+	assert.Equal(test, "huge", windows[0].Key)
+	assert.Equal(test, 2, windows[0].Count)
+	assert.Equal(test, time.Unix(0, 0).Format(time.RFC3339Nano), windows[0].Start)
+	assert.Equal(test, map[string]interface{}{"count": 2.0, "sum": 14.0, "min": 6.0, "max": 8.0, "avg": 7.0}, windows[0].Fields["weight"])
+	assert.Equal(test, "pixie", windows[1].Key)
+
+	flushed, err := config.Flush(time.Now(), false)
+	assert.Nil(test, err)
+	assert.Empty(test, flushed, "windows timed by a field only close as messages come in")
+
+	flushed, err = config.Flush(time.Now(), true)
+	assert.Nil(test, err)
+	windows = decodeWindows(test, flushed)
+	assert.Len(test, windows, 1)
+	assert.Equal(test, 1, windows[0].Count)
+	assert.Equal(test, time.Unix(60, 0).Format(time.RFC3339Nano), windows[0].Start)
+}
+
+func TestWindow_sliding(test *testing.T) {
+	config := windowOf(test, &windowConfig{Size: "10s", Slide: "5s", TimeField: "at"})
+
+	for _, at := range []int{1, 6, 11} {
+		out, err := config.FlatMap([]byte(fmt.Sprintf(`{"at": %d}`, at)))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if at == 11 {
+			windows := decodeWindows(test, out)
+			assert.Len(test, windows, 1)
+			assert.Equal(test, 2, windows[0].Count, "[-5s, 5s) and [0s, 10s) have closed, the first holding 1 message")
+		}
+	}
+
+	flushed, err := config.Flush(time.Now(), true)
+	assert.Nil(test, err)
+	counts := make([]int, 0)
+	for _, each := range decodeWindows(test, flushed) {
+		counts = append(counts, each.Count)
+	}
+
+	assert.Equal(test, []int{2, 1}, counts)
+}
+
+func TestWindow_count(test *testing.T) {
+	config := windowOf(test, &windowConfig{Key: "cat", Count: 2, Fields: []string{"weight"}, Aggregates: []string{"sum"}})
+
+	closed := make([][]byte, 0)
+	for _, message := range []string{
+		`{"cat": "huge", "weight": 6}`,
+		`{"cat": "pixie", "weight": 3}`,
+		`{"cat": "huge", "weight": 8}`,
+	} {
+		out, err := config.FlatMap([]byte(message))
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		closed = append(closed, out...)
+	}
+
+	windows := decodeWindows(test, closed)
+	assert.Len(test, windows, 1)
+	assert.Equal(test, map[string]interface{}{"sum": 14.0}, windows[0].Fields["weight"])
+
+	flushed, err := config.Flush(time.Now(), true)
+	assert.Nil(test, err)
+	windows = decodeWindows(test, flushed)
+	assert.Len(test, windows, 1)
+	assert.Equal(test, "pixie", windows[0].Key)
+}
+
+func TestWindow_config(test *testing.T) {
+	for _, config := range []*windowConfig{
+		{},
+		{Size: "1m", Count: 10},
+		{Size: "1m", Slide: "2m"},
+		{Size: "soon"},
+		{Size: "1m", Aggregates: []string{"median"}},
+	} {
+		assert.NotNil(test, config.init())
+	}
+}